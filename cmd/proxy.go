@@ -1,28 +1,47 @@
 package cmd
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/vulcanize/gap-filler/pkg/auth"
+	"github.com/vulcanize/gap-filler/pkg/cache"
+	"github.com/vulcanize/gap-filler/pkg/jobs"
 	"github.com/vulcanize/gap-filler/pkg/mux"
+	"github.com/vulcanize/gap-filler/pkg/notify"
+	"github.com/vulcanize/gap-filler/pkg/prom"
+	"github.com/vulcanize/gap-filler/pkg/proxy"
+	"github.com/vulcanize/gap-filler/pkg/qlservices"
+	"github.com/vulcanize/gap-filler/pkg/rpcpool"
+	"github.com/vulcanize/gap-filler/pkg/tlsconfig"
 )
 
 var (
-	ErrNoRpcEndpoints = errors.New("no rpc endpoints is available")
-
 	proxyCmd = &cobra.Command{
 		Use: "proxy",
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
 			fmt.Println()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			// SIGINT/SIGTERM start graceful shutdown
+			sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			// ctx is the server lifetime, it cancels requests and gap-fills
+			// left after the shutdown timeout
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
 			gqlDefaultAddr, err := url.Parse(viper.GetString("gql.default"))
 			if err != nil {
 				return err
@@ -33,28 +52,188 @@ var (
 				return err
 			}
 
-			rpcClients, err := parseRpcAddresses(viper.GetString("rpc.eth"))
+			gqlUpstreams := make(map[string]*url.URL)
+			for name, addr := range viper.GetStringMapString("gql.upstreams") {
+				uri, err := url.Parse(addr)
+				if err != nil {
+					logrus.Errorf("bad gql.upstreams %s address", name)
+					return err
+				}
+				gqlUpstreams[name] = uri
+			}
+			var gqlRoutes []proxy.Route
+			if err := viper.UnmarshalKey("gql.routes", &gqlRoutes); err != nil {
+				logrus.Error("bad gql.routes config")
+				return err
+			}
+
+			rpcPool, err := parseRpcAddresses(viper.GetString("rpc.eth"), "statediff")
 			if err != nil {
 				logrus.Error("bad rpc.eth addresses")
 				return err
 			}
+			defer rpcPool.Close()
 
-			tracingClients, err := parseRpcAddresses(viper.GetString("rpc.tracing"))
+			tracingPool, err := parseRpcAddresses(viper.GetString("rpc.tracing"))
 			if err != nil {
 				logrus.Error("bad rpc.tracing addresses")
 				return err
 			}
+			defer tracingPool.Close()
+
+			var services []qlservices.ServiceConfig
+			if err := viper.UnmarshalKey("services", &services); err != nil {
+				logrus.Error("bad services config")
+				return err
+			}
+
+			var jobStore jobs.Store
+			if path := viper.GetString("jobs.db"); path != "" {
+				store, err := jobs.NewBoltStore(path)
+				if err != nil {
+					logrus.Error("couldn't open jobs.db")
+					return err
+				}
+				defer store.Close()
+				jobStore = store
+			}
+
+			var responseCache cache.Cache
+			switch kind := viper.GetString("cache.type"); kind {
+			case "", "off":
+			case "memory":
+				lru, err := cache.NewLRU(viper.GetInt("cache.entries"), viper.GetInt("cache.max-bytes"))
+				if err != nil {
+					logrus.Error("bad cache.entries")
+					return err
+				}
+				responseCache = lru
+			case "disk":
+				bolt, err := cache.NewBolt(viper.GetString("cache.db"))
+				if err != nil {
+					logrus.Error("couldn't open cache.db")
+					return err
+				}
+				defer bolt.Close()
+				responseCache = bolt
+			default:
+				return fmt.Errorf("unknown cache type: %s", kind)
+			}
+
+			var persistedStore cache.Cache
+			if entries := viper.GetInt("apq.entries"); entries > 0 {
+				lru, err := cache.NewLRU(entries, 0)
+				if err != nil {
+					logrus.Error("bad apq.entries")
+					return err
+				}
+				persistedStore = lru
+			}
+
+			var notifier proxy.Notifier
+			if dsn := viper.GetString("notify.dsn"); dsn != "" {
+				channel := viper.GetString("notify.channel")
+				if viper.GetBool("notify.triggers") {
+					if err := notify.InstallTriggers(dsn, channel); err != nil {
+						logrus.Error("couldn't install notify triggers")
+						return err
+					}
+				}
+				listener, err := notify.Listen(dsn, notify.Options{Channel: channel})
+				if err != nil {
+					logrus.Error("couldn't listen postgres notifications")
+					return err
+				}
+				defer listener.Close()
+				notifier = listener
+			}
+
+			var keys []auth.Key
+			if err := viper.UnmarshalKey("auth.keys", &keys); err != nil {
+				logrus.Error("bad auth.keys config")
+				return err
+			}
+			var authn auth.Chain
+			if len(keys) > 0 {
+				apiKeys, err := auth.NewAPIKeys(keys)
+				if err != nil {
+					logrus.Error("bad auth.keys config")
+					return err
+				}
+				authn = append(authn, apiKeys)
+			}
+			if secret := viper.GetString("auth.jwt-secret"); secret != "" {
+				authn = append(authn, auth.NewJWT([]byte(secret)))
+			}
+			var authenticator auth.Authenticator
+			if len(authn) > 0 {
+				authenticator = authn
+			}
 
 			router, err := mux.NewServeMux(&mux.Options{
+				Context:        ctx,
 				BasePath:       viper.GetString("http.path"),
 				EnableGraphiQL: viper.GetBool("gql.gui"),
 				Postgraphile: mux.PostgraphileOptions{
 					Default:    gqlDefaultAddr,
 					TracingAPI: gqlTracingAPIAddr,
+					Upstreams:  gqlUpstreams,
+					Routes:     gqlRoutes,
 				},
 				RPC: mux.RPCOptions{
-					DefaultClients: rpcClients,
-					TracingClients: tracingClients,
+					Default: rpcPool,
+					Tracing: tracingPool,
+				},
+				Services:     services,
+				RangeWorkers: viper.GetInt("fill.range-workers"),
+				Jobs: mux.JobsOptions{
+					Store: jobStore,
+					Queue: jobs.Options{
+						Retries:    viper.GetInt("jobs.retries"),
+						MinBackoff: viper.GetDuration("jobs.backoff"),
+						MaxBackoff: viper.GetDuration("jobs.max-backoff"),
+						Retention:  viper.GetDuration("jobs.retention"),
+					},
+				},
+				Notify: mux.NotifyOptions{
+					Notifier:    notifier,
+					Fallback:    viper.GetDuration("notify.fallback"),
+					MinInterval: viper.GetDuration("notify.min-interval"),
+				},
+				Auth: mux.AuthOptions{
+					Authenticator: authenticator,
+					Forward:       viper.GetBool("auth.forward"),
+				},
+				Limits: proxy.LimitsOptions{
+					ClientRate:  viper.GetFloat64("limit.client-rate"),
+					ClientBurst: viper.GetInt("limit.client-burst"),
+					MaxFills:    viper.GetInt("limit.max-fills"),
+					Policy:      proxy.LimitPolicy(viper.GetString("limit.policy")),
+				},
+				Cache: proxy.CacheOptions{
+					Cache:    responseCache,
+					Finality: viper.GetUint64("cache.finality"),
+					MaxAge:   viper.GetDuration("cache.max-age"),
+				},
+				Failures: proxy.FailuresOptions{
+					TTL: map[proxy.FailureCategory]time.Duration{
+						proxy.FailureNotFound:    viper.GetDuration("failures.not-found-ttl"),
+						proxy.FailureUnavailable: viper.GetDuration("failures.unavailable-ttl"),
+						proxy.FailureRPC:         viper.GetDuration("failures.rpc-error-ttl"),
+					},
+					AllowClear: viper.GetBool("failures.allow-clear"),
+				},
+				Persisted: proxy.PersistedOptions{
+					Store: persistedStore,
+				},
+				Schema: proxy.SchemaOptions{
+					TTL:      viper.GetDuration("gql.schema-ttl"),
+					RetryTTL: viper.GetDuration("gql.schema-retry"),
+				},
+				Headers: proxy.HeadersOptions{
+					Allow:  viper.GetStringSlice("forward.headers"),
+					Deny:   viper.GetStringSlice("forward.deny"),
+					Static: viper.GetStringMapString("forward.static"),
 				},
 			})
 			if err != nil {
@@ -62,31 +241,97 @@ var (
 				return err
 			}
 
-			addr := fmt.Sprintf("%s:%s", viper.GetString("http.host"), viper.GetString("http.port"))
-			return http.ListenAndServe(addr, router)
+			if viper.GetBool("metrics") {
+				prom.Init()
+				metricsAddr := fmt.Sprintf("%s:%s", viper.GetString("metrics.host"), viper.GetString("metrics.port"))
+				go func() {
+					logrus.Infof("serving metrics on %s", metricsAddr)
+					if err := http.ListenAndServe(metricsAddr, prom.Handler()); err != nil {
+						logrus.WithError(err).Error("metrics server")
+					}
+				}()
+			}
+
+			server := &http.Server{
+				Addr:              fmt.Sprintf("%s:%s", viper.GetString("http.host"), viper.GetString("http.port")),
+				Handler:           router,
+				ReadHeaderTimeout: viper.GetDuration("http.read-header-timeout"),
+				ReadTimeout:       viper.GetDuration("http.read-timeout"),
+				WriteTimeout:      viper.GetDuration("http.write-timeout"),
+				IdleTimeout:       viper.GetDuration("http.idle-timeout"),
+				BaseContext: func(net.Listener) context.Context {
+					return ctx
+				},
+			}
+			// websocket upgrades are served by the same listener, so TLS
+			// covers them as well
+			var reloader *tlsconfig.Reloader
+			tlsOpts := tlsconfig.Options{
+				CertFile:     viper.GetString("tls.cert"),
+				KeyFile:      viper.GetString("tls.key"),
+				ClientCAFile: viper.GetString("tls.client-ca"),
+			}
+			if tlsOpts.Enabled() {
+				reloader, err = tlsconfig.New(tlsOpts)
+				if err != nil {
+					logrus.Error("couldn't load tls certificates")
+					return err
+				}
+				defer reloader.Close()
+				server.TLSConfig = reloader.Config()
+			}
+			errc := make(chan error, 1)
+			go func() {
+				if reloader != nil {
+					logrus.Infof("serving tls on %s", server.Addr)
+					errc <- server.ListenAndServeTLS("", "")
+					return
+				}
+				logrus.Infof("serving on %s", server.Addr)
+				errc <- server.ListenAndServe()
+			}()
+			select {
+			case err := <-errc:
+				return err
+			case <-sigCtx.Done():
+			}
+
+			// stop accepting connections, wait for in-flight requests and
+			// then for async gap-fills, cancel what is left after the timeout
+			timeout := viper.GetDuration("http.shutdown-timeout")
+			logrus.Infof("shutting down, waiting up to %s for in-flight requests", timeout)
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+			defer cancelShutdown()
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				logrus.WithError(err).Warn("canceling in-flight requests")
+			}
+			if err := router.Shutdown(shutdownCtx); err != nil {
+				logrus.WithError(err).Error("jobs shutdown")
+			}
+			cancel()
+			server.Close()
+			return nil
 		},
 	}
 )
 
-func parseRpcAddresses(value string) ([]*rpc.Client, error) {
-	rpcAddresses := strings.Split(value, ",")
-	rpcClients := make([]*rpc.Client, 0, len(rpcAddresses))
-	for _, address := range rpcAddresses {
-		rpcClient, err := rpc.Dial(address)
-		if err != nil {
-			logrus.Errorf("couldn't connect to %s. Error: %s", address, err)
-			continue
-		}
-
-		rpcClients = append(rpcClients, rpcClient)
-	}
-
-	if len(rpcClients) == 0 {
-		logrus.Error(ErrNoRpcEndpoints)
-		return nil, ErrNoRpcEndpoints
+func parseRpcAddresses(value string, modules ...string) (*rpcpool.Pool, error) {
+	pool, err := rpcpool.Dial(strings.Split(value, ","), rpcpool.Options{
+		Strategy:       rpcpool.Strategy(viper.GetString("rpc.strategy")),
+		CheckInterval:  viper.GetDuration("rpc.check-interval"),
+		MaxFailures:    viper.GetInt("rpc.max-failures"),
+		Cooldown:       viper.GetDuration("rpc.cooldown"),
+		Modules:        modules,
+		MinRedial:      viper.GetDuration("rpc.min-redial"),
+		MaxRedial:      viper.GetDuration("rpc.max-redial"),
+		Confirmations:  viper.GetUint64("rpc.confirmations"),
+		MaxConcurrency: viper.GetInt("rpc.max-concurrency"),
+	})
+	if err != nil {
+		logrus.Error(err)
+		return nil, err
 	}
-
-	return rpcClients, nil
+	return pool, nil
 }
 
 func init() {
@@ -96,23 +341,140 @@ func init() {
 	proxyCmd.PersistentFlags().String("http-host", "127.0.0.1", "http host")
 	proxyCmd.PersistentFlags().String("http-port", "8080", "http port")
 	proxyCmd.PersistentFlags().String("http-path", "/", "http base path")
+	proxyCmd.PersistentFlags().Duration("http-read-header-timeout", 10*time.Second, "time to read request headers")
+	proxyCmd.PersistentFlags().Duration("http-read-timeout", 30*time.Second, "time to read the whole request, 0 means no timeout")
+	proxyCmd.PersistentFlags().Duration("http-write-timeout", time.Minute, "time to write the response including gap-fill and polling, 0 means no timeout")
+	proxyCmd.PersistentFlags().Duration("http-idle-timeout", 2*time.Minute, "keep-alive connection idle time")
+	proxyCmd.PersistentFlags().Duration("http-shutdown-timeout", 30*time.Second, "time to drain in-flight requests and gap-fills on SIGINT/SIGTERM")
+
+	proxyCmd.PersistentFlags().String("tls-cert", "", "PEM certificate file, enables TLS. Reloaded when the file changes")
+	proxyCmd.PersistentFlags().String("tls-key", "", "PEM private key file of the certificate")
+	proxyCmd.PersistentFlags().String("tls-client-ca", "", "PEM file of CAs verifying client certificates, enables mutual TLS")
+
+	proxyCmd.PersistentFlags().String("auth-jwt-secret", "", "HMAC secret of client JWTs, API keys are declared in the config as [[auth.keys]]")
+	proxyCmd.PersistentFlags().Bool("auth-forward", false, "pass the Authorization header of the client to postgraphile")
+
+	proxyCmd.PersistentFlags().StringSlice("forward-headers", proxy.DefaultForwardHeaders, "client headers passed to postgraphile, * passes all of them")
+	proxyCmd.PersistentFlags().StringSlice("forward-deny", nil, "client headers never passed to postgraphile, X-Request-Id and X-Forwarded-For included")
+	proxyCmd.PersistentFlags().StringToString("forward-static", nil, "headers set on every postgraphile request. Example X-Source=gap-filler")
 
 	proxyCmd.PersistentFlags().String("rpc-eth", "http://127.0.0.1:8545", "comma separated ethereum rpc addresses. Example http://127.0.0.1:8545,http://127.0.0.2:8545")
 	proxyCmd.PersistentFlags().String("rpc-tracing", "http://127.0.0.1:8000", "comma separated traicing api addresses")
+	proxyCmd.PersistentFlags().String("rpc-strategy", string(rpcpool.RoundRobin), "rpc endpoint choice: round-robin, least-loaded or priority")
+	proxyCmd.PersistentFlags().Duration("rpc-check-interval", 10*time.Second, "period of rpc endpoint health checks, 0 disables them")
+	proxyCmd.PersistentFlags().Int("rpc-max-failures", 3, "transport errors in a row which eject rpc endpoint")
+	proxyCmd.PersistentFlags().Duration("rpc-cooldown", 30*time.Second, "time ejected rpc endpoint waits for re-admission when health checks are disabled")
+	proxyCmd.PersistentFlags().Duration("rpc-min-redial", time.Second, "delay before redialing unreachable rpc endpoint, doubles every next attempt")
+	proxyCmd.PersistentFlags().Duration("rpc-max-redial", 30*time.Second, "max delay between redials of rpc endpoint")
+	proxyCmd.PersistentFlags().Uint64("rpc-confirmations", 0, "blocks on top of the block required before it's filled")
+	proxyCmd.PersistentFlags().Int("rpc-max-concurrency", 0, "calls in flight of a single rpc endpoint, 0 means no limit")
 
 	proxyCmd.PersistentFlags().String("gql-default", "http://127.0.0.1:5020/graphql", "postgraphile address")
 	proxyCmd.PersistentFlags().String("gql-tracing", "http://127.0.0.1:5020/graphql", "tracing api postgraphile address")
+	proxyCmd.PersistentFlags().StringToString("gql-upstreams", nil, "named postgraphile addresses, routes are declared in the config as [[gql.routes]]. Example archive=http://127.0.0.1:5021/graphql")
+	proxyCmd.PersistentFlags().Duration("gql-schema-ttl", 5*time.Minute, "time the schema merged of all upstreams is kept before introspecting them again")
+	proxyCmd.PersistentFlags().Duration("gql-schema-retry", 10*time.Second, "time the schema merged while some upstreams failed is kept before introspecting them again")
 	proxyCmd.PersistentFlags().Bool("gql-gui", false, "enable graphiql interface")
 
+	proxyCmd.PersistentFlags().Int("fill-range-workers", 4, "max parallel statediff calls for a single block range query")
+
+	proxyCmd.PersistentFlags().Float64("limit-client-rate", 0, "gap-fill calls per second of a single client, 0 means no limit")
+	proxyCmd.PersistentFlags().Int("limit-client-burst", 10, "gap-fill calls a client may make at once above the rate")
+	proxyCmd.PersistentFlags().Int("limit-max-fills", 0, "gap-fill calls in flight across all clients, 0 means no limit")
+	proxyCmd.PersistentFlags().String("limit-policy", string(proxy.LimitQueue), "gap-fills over the limits: queue or reject")
+
+	proxyCmd.PersistentFlags().String("cache-type", "off", "cache of responses for final blocks: off, memory or disk")
+	proxyCmd.PersistentFlags().Int("cache-entries", 10000, "max responses in the memory cache")
+	proxyCmd.PersistentFlags().Int("cache-max-bytes", 256<<20, "max size of responses in the memory cache, 0 means no limit")
+	proxyCmd.PersistentFlags().String("cache-db", "gap-filler-cache.db", "BoltDB file of the disk cache")
+	proxyCmd.PersistentFlags().Uint64("cache-finality", 64, "blocks behind the chain head which are cached")
+	proxyCmd.PersistentFlags().Duration("cache-max-age", time.Hour, "max-age of GET responses of final blocks, 0 disables it")
+	proxyCmd.PersistentFlags().Int("apq-entries", 1000, "max automatic persisted queries in memory, 0 disables them")
+
+	proxyCmd.PersistentFlags().Duration("failures-not-found-ttl", 5*time.Minute, "skip gap-fills of blocks unknown to the node for this time, 0 disables it")
+	proxyCmd.PersistentFlags().Duration("failures-unavailable-ttl", time.Hour, "skip gap-fills of blocks with pruned state for this time, 0 disables it")
+	proxyCmd.PersistentFlags().Duration("failures-rpc-error-ttl", time.Minute, "skip gap-fills failed with other rpc errors for this time, 0 disables it")
+	proxyCmd.PersistentFlags().Bool("failures-allow-clear", false, "allow clearing failures without authentication")
+
+	proxyCmd.PersistentFlags().String("notify-dsn", "", "postgres connection LISTENing for indexed rows to wake up polling, polling only if empty")
+	proxyCmd.PersistentFlags().String("notify-channel", notify.DefaultChannel, "postgres notification channel")
+	proxyCmd.PersistentFlags().Bool("notify-triggers", false, "install triggers notifying the channel about inserts into eth.header_cids and eth.transaction_cids")
+	proxyCmd.PersistentFlags().Duration("notify-fallback", 2*time.Second, "polling period when notifications are used")
+	proxyCmd.PersistentFlags().Duration("notify-min-interval", 100*time.Millisecond, "min time between postgraphile requests of a polling woken up by notifications")
+
+	proxyCmd.PersistentFlags().String("jobs-db", "", "BoltDB file keeping gap-fill jobs across restarts, in-memory if empty")
+	proxyCmd.PersistentFlags().Int("jobs-retries", 5, "retries of a failed gap-fill job")
+	proxyCmd.PersistentFlags().Duration("jobs-backoff", 500*time.Millisecond, "delay before the first retry, doubles every next one")
+	proxyCmd.PersistentFlags().Duration("jobs-max-backoff", 30*time.Second, "max delay between retries")
+	proxyCmd.PersistentFlags().Duration("jobs-retention", 24*time.Hour, "how long finished jobs are kept")
+
 	// and their .toml config bindings
 	viper.BindPFlag("http.host", proxyCmd.PersistentFlags().Lookup("http-host"))
 	viper.BindPFlag("http.port", proxyCmd.PersistentFlags().Lookup("http-port"))
 	viper.BindPFlag("http.path", proxyCmd.PersistentFlags().Lookup("http-path"))
+	viper.BindPFlag("http.read-header-timeout", proxyCmd.PersistentFlags().Lookup("http-read-header-timeout"))
+	viper.BindPFlag("http.read-timeout", proxyCmd.PersistentFlags().Lookup("http-read-timeout"))
+	viper.BindPFlag("http.write-timeout", proxyCmd.PersistentFlags().Lookup("http-write-timeout"))
+	viper.BindPFlag("http.idle-timeout", proxyCmd.PersistentFlags().Lookup("http-idle-timeout"))
+	viper.BindPFlag("http.shutdown-timeout", proxyCmd.PersistentFlags().Lookup("http-shutdown-timeout"))
+
+	viper.BindPFlag("tls.cert", proxyCmd.PersistentFlags().Lookup("tls-cert"))
+	viper.BindPFlag("tls.key", proxyCmd.PersistentFlags().Lookup("tls-key"))
+	viper.BindPFlag("tls.client-ca", proxyCmd.PersistentFlags().Lookup("tls-client-ca"))
+
+	viper.BindPFlag("auth.jwt-secret", proxyCmd.PersistentFlags().Lookup("auth-jwt-secret"))
+	viper.BindPFlag("auth.forward", proxyCmd.PersistentFlags().Lookup("auth-forward"))
+	viper.BindPFlag("forward.headers", proxyCmd.PersistentFlags().Lookup("forward-headers"))
+	viper.BindPFlag("forward.deny", proxyCmd.PersistentFlags().Lookup("forward-deny"))
+	viper.BindPFlag("forward.static", proxyCmd.PersistentFlags().Lookup("forward-static"))
 
 	viper.BindPFlag("rpc.eth", proxyCmd.PersistentFlags().Lookup("rpc-eth"))
 	viper.BindPFlag("rpc.tracing", proxyCmd.PersistentFlags().Lookup("rpc-tracing"))
+	viper.BindPFlag("rpc.strategy", proxyCmd.PersistentFlags().Lookup("rpc-strategy"))
+	viper.BindPFlag("rpc.check-interval", proxyCmd.PersistentFlags().Lookup("rpc-check-interval"))
+	viper.BindPFlag("rpc.max-failures", proxyCmd.PersistentFlags().Lookup("rpc-max-failures"))
+	viper.BindPFlag("rpc.cooldown", proxyCmd.PersistentFlags().Lookup("rpc-cooldown"))
+	viper.BindPFlag("rpc.min-redial", proxyCmd.PersistentFlags().Lookup("rpc-min-redial"))
+	viper.BindPFlag("rpc.max-redial", proxyCmd.PersistentFlags().Lookup("rpc-max-redial"))
+	viper.BindPFlag("rpc.confirmations", proxyCmd.PersistentFlags().Lookup("rpc-confirmations"))
+	viper.BindPFlag("rpc.max-concurrency", proxyCmd.PersistentFlags().Lookup("rpc-max-concurrency"))
 
 	viper.BindPFlag("gql.default", proxyCmd.PersistentFlags().Lookup("gql-default"))
 	viper.BindPFlag("gql.tracing", proxyCmd.PersistentFlags().Lookup("gql-tracing"))
+	viper.BindPFlag("gql.upstreams", proxyCmd.PersistentFlags().Lookup("gql-upstreams"))
+	viper.BindPFlag("gql.schema-ttl", proxyCmd.PersistentFlags().Lookup("gql-schema-ttl"))
+	viper.BindPFlag("gql.schema-retry", proxyCmd.PersistentFlags().Lookup("gql-schema-retry"))
 	viper.BindPFlag("gql.gui", proxyCmd.PersistentFlags().Lookup("gql-gui"))
+
+	viper.BindPFlag("fill.range-workers", proxyCmd.PersistentFlags().Lookup("fill-range-workers"))
+
+	viper.BindPFlag("limit.client-rate", proxyCmd.PersistentFlags().Lookup("limit-client-rate"))
+	viper.BindPFlag("limit.client-burst", proxyCmd.PersistentFlags().Lookup("limit-client-burst"))
+	viper.BindPFlag("limit.max-fills", proxyCmd.PersistentFlags().Lookup("limit-max-fills"))
+	viper.BindPFlag("limit.policy", proxyCmd.PersistentFlags().Lookup("limit-policy"))
+
+	viper.BindPFlag("cache.type", proxyCmd.PersistentFlags().Lookup("cache-type"))
+	viper.BindPFlag("cache.entries", proxyCmd.PersistentFlags().Lookup("cache-entries"))
+	viper.BindPFlag("cache.max-bytes", proxyCmd.PersistentFlags().Lookup("cache-max-bytes"))
+	viper.BindPFlag("cache.db", proxyCmd.PersistentFlags().Lookup("cache-db"))
+	viper.BindPFlag("cache.finality", proxyCmd.PersistentFlags().Lookup("cache-finality"))
+	viper.BindPFlag("cache.max-age", proxyCmd.PersistentFlags().Lookup("cache-max-age"))
+	viper.BindPFlag("apq.entries", proxyCmd.PersistentFlags().Lookup("apq-entries"))
+
+	viper.BindPFlag("failures.not-found-ttl", proxyCmd.PersistentFlags().Lookup("failures-not-found-ttl"))
+	viper.BindPFlag("failures.unavailable-ttl", proxyCmd.PersistentFlags().Lookup("failures-unavailable-ttl"))
+	viper.BindPFlag("failures.rpc-error-ttl", proxyCmd.PersistentFlags().Lookup("failures-rpc-error-ttl"))
+	viper.BindPFlag("failures.allow-clear", proxyCmd.PersistentFlags().Lookup("failures-allow-clear"))
+
+	viper.BindPFlag("notify.dsn", proxyCmd.PersistentFlags().Lookup("notify-dsn"))
+	viper.BindPFlag("notify.channel", proxyCmd.PersistentFlags().Lookup("notify-channel"))
+	viper.BindPFlag("notify.triggers", proxyCmd.PersistentFlags().Lookup("notify-triggers"))
+	viper.BindPFlag("notify.fallback", proxyCmd.PersistentFlags().Lookup("notify-fallback"))
+	viper.BindPFlag("notify.min-interval", proxyCmd.PersistentFlags().Lookup("notify-min-interval"))
+
+	viper.BindPFlag("jobs.db", proxyCmd.PersistentFlags().Lookup("jobs-db"))
+	viper.BindPFlag("jobs.retries", proxyCmd.PersistentFlags().Lookup("jobs-retries"))
+	viper.BindPFlag("jobs.backoff", proxyCmd.PersistentFlags().Lookup("jobs-backoff"))
+	viper.BindPFlag("jobs.max-backoff", proxyCmd.PersistentFlags().Lookup("jobs-max-backoff"))
+	viper.BindPFlag("jobs.retention", proxyCmd.PersistentFlags().Lookup("jobs-retention"))
 }
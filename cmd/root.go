@@ -49,7 +49,7 @@ func init() {
 
 	rootCmd.PersistentFlags().Bool("metrics", false, "enable prometheus")
 	rootCmd.PersistentFlags().String("metrics-host", "127.0.0.1", "prometheus http host")
-	rootCmd.PersistentFlags().String("metrics-port", "8080", "prometheus http port")
+	rootCmd.PersistentFlags().String("metrics-port", "9090", "prometheus http port")
 
 	// and their .toml config bindings
 	viper.BindPFlag("log.file", rootCmd.PersistentFlags().Lookup("log-file"))
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Claims of gap-filler tokens, `sub` is the client id
+type Claims struct {
+	jwt.RegisteredClaims
+	// Fill allows the client to trigger gap-fills
+	Fill bool `json:"gapfill,omitempty"`
+	// Services the client may fill, all services when it's empty
+	Services []string `json:"services,omitempty"`
+	// Admin allows the client to manage gap-filler state
+	Admin bool `json:"admin,omitempty"`
+}
+
+// JWT authenticates clients by HMAC signed bearer tokens
+type JWT struct {
+	secret []byte
+	parser *jwt.Parser
+}
+
+// NewJWT create authenticator verifying tokens with the shared secret
+func NewJWT(secret []byte) *JWT {
+	return &JWT{
+		secret: secret,
+		parser: jwt.NewParser(jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"})),
+	}
+}
+
+// Authenticate implements Authenticator
+func (authn *JWT) Authenticate(r *http.Request) (*Client, error) {
+	token := bearer(r)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	claims := new(Claims)
+	_, err := authn.parser.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return authn.secret, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthorized, err)
+	}
+	return &Client{
+		ID:       claims.Subject,
+		Fill:     claims.Fill,
+		Services: claims.Services,
+		Admin:    claims.Admin,
+	}, nil
+}
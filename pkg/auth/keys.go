@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Key is a static API key of the client, it's declared in the config as
+//
+//	[[auth.keys]]
+//	key = "secret"
+//	client = "indexer"
+//	fill = true
+//	services = ["ethHeaderCidByBlockNumber"]
+type Key struct {
+	Key    string `mapstructure:"key"`
+	Client `mapstructure:",squash"`
+}
+
+// APIKeys authenticates clients by the static keys, keys are compared in
+// constant time by their hashes
+type APIKeys []apiKey
+
+type apiKey struct {
+	sum    [sha256.Size]byte
+	client *Client
+}
+
+// NewAPIKeys index the keys, the client id defaults to the key position.
+// Empty keys are rejected, they would match requests without credentials
+func NewAPIKeys(keys []Key) (APIKeys, error) {
+	authn := make(APIKeys, 0, len(keys))
+	for i := range keys {
+		if keys[i].Key == "" {
+			return nil, fmt.Errorf("key %d: %w", i, ErrEmptyKey)
+		}
+		client := keys[i].Client
+		if client.ID == "" {
+			client.ID = "key-" + strconv.Itoa(i)
+		}
+		authn = append(authn, apiKey{sha256.Sum256([]byte(keys[i].Key)), &client})
+	}
+	return authn, nil
+}
+
+// lookup the client of the key, every key is compared so the time doesn't
+// depend on which one matches
+func (keys APIKeys) lookup(key string) (*Client, bool) {
+	if key == "" {
+		return nil, false
+	}
+	sum := sha256.Sum256([]byte(key))
+	var found *Client
+	for i := range keys {
+		if subtle.ConstantTimeCompare(sum[:], keys[i].sum[:]) == 1 {
+			found = keys[i].client
+		}
+	}
+	return found, found != nil
+}
+
+// Authenticate implements Authenticator. The key is taken from KeyHeader or
+// the bearer token, an unknown bearer token is left to the next
+// authenticator since it may be a JWT
+func (keys APIKeys) Authenticate(r *http.Request) (*Client, error) {
+	if key := r.Header.Get(KeyHeader); key != "" {
+		if client, ok := keys.lookup(key); ok {
+			return client, nil
+		}
+		return nil, ErrUnauthorized
+	}
+	if client, ok := keys.lookup(bearer(r)); ok {
+		return client, nil
+	}
+	return nil, ErrNoCredentials
+}
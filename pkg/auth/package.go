@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// List of errors
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNoCredentials is returned by an authenticator when the request
+	// doesn't carry its kind of credentials, the next one is tried then
+	ErrNoCredentials = errors.New("no credentials")
+	ErrEmptyKey      = errors.New("empty api key")
+)
+
+// CodeUnauthenticated is `extensions.code` of rejected requests
+const CodeUnauthenticated = "UNAUTHENTICATED"
+
+// KeyHeader carries the static API key, `Authorization: Bearer <key>` is
+// accepted as well
+const KeyHeader = "X-Api-Key"
+
+// Client is the authenticated caller and its permissions
+type Client struct {
+	ID string `mapstructure:"client"`
+	// Fill allows the client to trigger gap-fills, read-only clients are
+	// only proxied to postgraphile
+	Fill bool `mapstructure:"fill"`
+	// Services the client may fill, all services when it's empty
+	Services []string `mapstructure:"services"`
+	// Admin allows the client to manage gap-filler state, e.g. clear
+	// memoized failures
+	Admin bool `mapstructure:"admin"`
+}
+
+// CanFill reports whether the client may trigger the gap-fill of the service
+func (c *Client) CanFill(service string) bool {
+	if !c.Fill {
+		return false
+	}
+	if len(c.Services) == 0 {
+		return true
+	}
+	for _, name := range c.Services {
+		if name == service {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator identifies the client of the request
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Client, error)
+}
+
+// Chain tries authenticators in order until one of them finds credentials
+type Chain []Authenticator
+
+// Authenticate implements Authenticator
+func (chain Chain) Authenticate(r *http.Request) (*Client, error) {
+	for _, authn := range chain {
+		client, err := authn.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return client, err
+	}
+	return nil, ErrUnauthorized
+}
+
+type clientKey struct{}
+
+// WithClient returns the context carrying the client
+func WithClient(ctx context.Context, client *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// FromContext returns the client of the request, ok is false when the
+// request isn't authenticated at all
+func FromContext(ctx context.Context) (client *Client, ok bool) {
+	client, ok = ctx.Value(clientKey{}).(*Client)
+	return
+}
+
+// Middleware rejects requests which don't pass the authenticator with 401,
+// the client of the rest is put into the request context
+func Middleware(authn Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, err := authn.Authenticate(r)
+		if err != nil {
+			if errors.Is(err, ErrNoCredentials) {
+				err = ErrUnauthorized
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gap-filler"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"errors": []interface{}{map[string]interface{}{
+					"message":    err.Error(),
+					"extensions": map[string]string{"code": CodeUnauthenticated},
+				}},
+			})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithClient(r.Context(), client)))
+	})
+}
+
+// bearer returns the token of `Authorization: Bearer <token>` header
+func bearer(r *http.Request) string {
+	value := r.Header.Get("Authorization")
+	if len(value) < 7 || !strings.EqualFold(value[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(value[7:])
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func sign(t *testing.T, method jwt.SigningMethod, secret []byte, claims Claims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthenticate(t *testing.T) {
+	secret := []byte("secret")
+	keys, err := NewAPIKeys([]Key{
+		{Key: "reader-key", Client: Client{ID: "reader"}},
+		{Key: "filler-key", Client: Client{Fill: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authn := Chain{keys, NewJWT(secret)}
+	valid := sign(t, jwt.SigningMethodHS256, secret, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "indexer"},
+		Fill:             true,
+		Services:         []string{"allEthHeaderCids"},
+	})
+	expired := sign(t, jwt.SigningMethodHS256, secret, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "indexer", ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))},
+	})
+	forged := sign(t, jwt.SigningMethodHS256, []byte("other"), Claims{Fill: true})
+
+	for _, tc := range []struct {
+		name   string
+		header map[string]string
+		client *Client
+		err    error
+	}{
+		{"no credentials", nil, nil, ErrUnauthorized},
+		{"key header", map[string]string{KeyHeader: "reader-key"}, &Client{ID: "reader"}, nil},
+		{"bearer key", map[string]string{"Authorization": "Bearer filler-key"}, &Client{ID: "key-1", Fill: true}, nil},
+		{"unknown key", map[string]string{KeyHeader: "bad"}, nil, ErrUnauthorized},
+		{"jwt", map[string]string{"Authorization": "bearer " + valid}, &Client{ID: "indexer", Fill: true, Services: []string{"allEthHeaderCids"}}, nil},
+		{"expired jwt", map[string]string{"Authorization": "Bearer " + expired}, nil, ErrUnauthorized},
+		{"forged jwt", map[string]string{"Authorization": "Bearer " + forged}, nil, ErrUnauthorized},
+	} {
+		r := httptest.NewRequest("POST", "/graphql", nil)
+		for name, value := range tc.header {
+			r.Header.Set(name, value)
+		}
+		client, err := authn.Authenticate(r)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("[%s] Want: %v, Got: %v", tc.name, tc.err, err)
+			continue
+		}
+		if tc.client == nil {
+			continue
+		}
+		if client.ID != tc.client.ID || client.Fill != tc.client.Fill || len(client.Services) != len(tc.client.Services) {
+			t.Errorf("[%s] Want: %+v, Got: %+v", tc.name, tc.client, client)
+		}
+	}
+}
+
+func TestCanFill(t *testing.T) {
+	for _, tc := range []struct {
+		client  Client
+		service string
+		want    bool
+	}{
+		{Client{}, "allEthHeaderCids", false},
+		{Client{Fill: true}, "allEthHeaderCids", true},
+		{Client{Fill: true, Services: []string{"allEthHeaderCids"}}, "allEthHeaderCids", true},
+		{Client{Fill: true, Services: []string{"allEthHeaderCids"}}, "graphTransactionByTxHash", false},
+	} {
+		if got := tc.client.CanFill(tc.service); got != tc.want {
+			t.Errorf("%+v.CanFill(%s) Want: %v, Got: %v", tc.client, tc.service, tc.want, got)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var got *Client
+	keys, err := NewAPIKeys([]Key{{Key: "key", Client: Client{ID: "reader"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Middleware(keys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(r.Context())
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/graphql", nil))
+	if rr.Code != http.StatusUnauthorized || got != nil {
+		t.Errorf("Want: 401, Got: %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/graphql", nil)
+	r.Header.Set(KeyHeader, "key")
+	handler.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK || got == nil || got.ID != "reader" {
+		t.Errorf("Want: 200 and reader client, Got: %d %+v", rr.Code, got)
+	}
+}
+
+func TestEmptyAPIKey(t *testing.T) {
+	if _, err := NewAPIKeys([]Key{{Key: "key"}, {Key: "", Client: Client{Admin: true}}}); !errors.Is(err, ErrEmptyKey) {
+		t.Errorf("Want: %v, Got: %v", ErrEmptyKey, err)
+	}
+
+	// even a key of the empty string doesn't match requests without credentials
+	keys := APIKeys{{sha256.Sum256(nil), &Client{ID: "empty", Admin: true}}}
+	if client, err := keys.Authenticate(httptest.NewRequest("POST", "/graphql", nil)); client != nil || !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Want: %v for anonymous request, Got: %+v %v", ErrNoCredentials, client, err)
+	}
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucket = []byte("responses")
+
+// Cache keeps postgraphile responses of immutable queries
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Close() error
+}
+
+// LRU keeps responses in memory, the least recently used ones are evicted
+// when either of the bounds is exceeded
+type LRU struct {
+	mu       sync.Mutex
+	lru      *simplelru.LRU
+	size     int
+	maxBytes int
+}
+
+// NewLRU create cache of at most maxEntries responses and maxBytes of their
+// size, zero maxBytes means no byte bound
+func NewLRU(maxEntries, maxBytes int) (*LRU, error) {
+	cache := &LRU{maxBytes: maxBytes}
+	lru, err := simplelru.NewLRU(maxEntries, func(key, value interface{}) {
+		cache.size -= len(value.([]byte))
+	})
+	if err != nil {
+		return nil, err
+	}
+	cache.lru = lru
+	return cache, nil
+}
+
+func (cache *LRU) Get(key string) ([]byte, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	value, ok := cache.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return value.([]byte), true
+}
+
+func (cache *LRU) Set(key string, value []byte) {
+	if cache.maxBytes > 0 && len(value) > cache.maxBytes {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.lru.Remove(key)
+	cache.lru.Add(key, value)
+	cache.size += len(value)
+	for cache.maxBytes > 0 && cache.size > cache.maxBytes {
+		cache.lru.RemoveOldest()
+	}
+}
+
+// Len returns the number of cached responses
+func (cache *LRU) Len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.lru.Len()
+}
+
+func (cache *LRU) Close() error {
+	return nil
+}
+
+// Bolt keeps responses in BoltDB file, they survive a restart. Cached
+// responses never change, so the file isn't bounded
+type Bolt struct {
+	db *bolt.DB
+}
+
+// NewBolt open or create BoltDB file
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db}, nil
+}
+
+func (cache *Bolt) Get(key string) ([]byte, bool) {
+	var value []byte
+	cache.db.View(func(tx *bolt.Tx) error {
+		// the slice is valid only inside the transaction
+		if data := tx.Bucket(bucket).Get([]byte(key)); data != nil {
+			value = append([]byte(nil), data...)
+		}
+		return nil
+	})
+	return value, value != nil
+}
+
+func (cache *Bolt) Set(key string, value []byte) {
+	cache.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), value)
+	})
+}
+
+func (cache *Bolt) Close() error {
+	return cache.db.Close()
+}
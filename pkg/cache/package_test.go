@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLRUBounds(t *testing.T) {
+	cache, err := NewLRU(3, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("a", []byte("1234"))
+	cache.Set("b", []byte("1234"))
+	cache.Get("a")
+	// exceeds maxBytes, the least recently used b is evicted
+	cache.Set("c", []byte("1234"))
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Want: b is evicted by size")
+	}
+	cache.Set("d", []byte("1"))
+	cache.Set("e", []byte("1"))
+	if cache.Len() != 3 {
+		t.Errorf("Want: 3 entries, Got: %d", cache.Len())
+	}
+	cache.Set("big", []byte("12345678901"))
+	if _, ok := cache.Get("big"); ok {
+		t.Error("Want: value over maxBytes isn't cached")
+	}
+}
+
+func TestBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	cache, err := NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("key", []byte("value"))
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if value, ok := cache.Get("key"); !ok || string(value) != "value" {
+		t.Errorf("Want: value after reopen, Got: %q %v", value, ok)
+	}
+	if _, ok := cache.Get("missing"); ok {
+		t.Error("Want: missing key isn't found")
+	}
+}
@@ -0,0 +1,445 @@
+package jobs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vulcanize/gap-filler/pkg/prom"
+)
+
+// List of errors
+var (
+	ErrNotFound = errors.New("job not found")
+	ErrClosed   = errors.New("job queue is closed")
+)
+
+// permanentError is returned by RunFunc when retries can't help
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wrap the error to fail the job without retries
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether the error fails the job without retries
+func IsPermanent(err error) bool {
+	var perm permanentError
+	return errors.As(err, &perm)
+}
+
+// Status of the job
+type Status string
+
+// List of job statuses
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// Job is a single gap-fill of the service for the normalized arguments
+type Job struct {
+	ID       string    `json:"id"`
+	Service  string    `json:"service"`
+	Args     string    `json:"args"`
+	Status   Status    `json:"status"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+
+	done chan struct{}
+	err  error
+	// attempted is closed when the first attempt is finished
+	attempted  chan struct{}
+	attemptErr error
+}
+
+// Done is closed when the job is finished
+func (job *Job) Done() <-chan struct{} {
+	return job.done
+}
+
+// Err returns the last error of the finished job
+func (job *Job) Err() error {
+	return job.err
+}
+
+// Attempted is closed when the first attempt of the job is finished, the
+// job may be retried after it
+func (job *Job) Attempted() <-chan struct{} {
+	return job.attempted
+}
+
+// AttemptErr returns the error of the first attempt after Attempted is closed
+func (job *Job) AttemptErr() error {
+	return job.attemptErr
+}
+
+// endAttempt is called only by the goroutine processing the job
+func (job *Job) endAttempt(err error) {
+	select {
+	case <-job.attempted:
+	default:
+		job.attemptErr = err
+		close(job.attempted)
+	}
+}
+
+func (job *Job) snapshot() Job {
+	return Job{
+		ID:       job.ID,
+		Service:  job.Service,
+		Args:     job.Args,
+		Status:   job.Status,
+		Attempts: job.Attempts,
+		Error:    job.Error,
+		Created:  job.Created,
+		Updated:  job.Updated,
+	}
+}
+
+// ID of the job for the service and normalized arguments
+func ID(service, args string) string {
+	sum := sha256.Sum256([]byte(service + "(" + args + ")"))
+	return hex.EncodeToString(sum[:16])
+}
+
+// RunFunc do the job once, the context is canceled when the queue is closed
+type RunFunc func(ctx context.Context, service, args string) error
+
+// Options of the job queue
+type Options struct {
+	// Retries is the number of attempts after the first failed one
+	Retries int
+	// MinBackoff is the delay before the first retry, it doubles every next one
+	MinBackoff time.Duration
+	// MaxBackoff limits the delay between retries
+	MaxBackoff time.Duration
+	// Retention is how long finished jobs are kept in the store
+	Retention time.Duration
+}
+
+// Queue runs jobs, deduplicates concurrent jobs with the same key and
+// retries failed ones with exponential backoff
+type Queue struct {
+	store  Store
+	run    RunFunc
+	opts   Options
+	mu     sync.Mutex
+	active map[string]*Job
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed bool
+	// pruned is closed when the pruning loop is stopped
+	pruned chan struct{}
+
+	closeOnce sync.Once
+}
+
+// NewQueue create new job queue, running jobs are canceled when the context
+// is done or the queue is closed. The store is closed by its owner after
+// the queue is closed
+func NewQueue(ctx context.Context, store Store, run RunFunc, opts Options) *Queue {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 500 * time.Millisecond
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = opts.MinBackoff
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 24 * time.Hour
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	q := &Queue{
+		store:  store,
+		run:    run,
+		opts:   opts,
+		active: make(map[string]*Job),
+		ctx:    ctx,
+		cancel: cancel,
+		pruned: make(chan struct{}),
+	}
+	go q.pruneLoop()
+	return q
+}
+
+// pruneLoop drop finished jobs older than the retention until the queue is
+// closed
+func (q *Queue) pruneLoop() {
+	defer close(q.pruned)
+	interval := q.opts.Retention / 2
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := q.prune(); err != nil {
+				logrus.WithError(err).Error("couldn't prune finished jobs")
+			}
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+// prune drop finished jobs older than the retention from the store
+func (q *Queue) prune() error {
+	jobs, err := q.store.All()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		switch job.Status {
+		case StatusPending, StatusRunning:
+			continue
+		}
+		if time.Since(job.Updated) > q.opts.Retention {
+			if err := q.store.Delete(job.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Submit start the job or return the active one with the same key
+func (q *Queue) Submit(service, args string) *Job {
+	now := time.Now()
+	return q.submit(&Job{
+		ID:      ID(service, args),
+		Service: service,
+		Args:    args,
+		Created: now,
+	})
+}
+
+func (q *Queue) submit(job *Job) *Job {
+	q.mu.Lock()
+	if active, ok := q.active[job.ID]; ok {
+		q.mu.Unlock()
+		return active
+	}
+	job.Status = StatusPending
+	job.Updated = time.Now()
+	job.done = make(chan struct{})
+	job.attempted = make(chan struct{})
+	if q.closed {
+		q.mu.Unlock()
+		job.err = ErrClosed
+		job.endAttempt(ErrClosed)
+		close(job.done)
+		return job
+	}
+	q.active[job.ID] = job
+	snapshot := job.snapshot()
+	q.wg.Add(1)
+	q.mu.Unlock()
+
+	// the pending state is saved before the job is started, so it can't
+	// overwrite the later ones
+	q.save(&snapshot)
+	prom.JobStarted()
+	go q.process(job)
+	return job
+}
+
+// Resume restart pending jobs left in the store and drop old finished ones
+func (q *Queue) Resume() error {
+	jobs, err := q.store.All()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		switch job.Status {
+		case StatusPending, StatusRunning:
+			logrus.WithField("job", job.ID).Infof("resume %s(%s) job", job.Service, job.Args)
+			job.Attempts = 0
+			q.submit(job)
+		}
+	}
+	return q.prune()
+}
+
+// Get job by id
+func (q *Queue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	if job, ok := q.active[id]; ok {
+		defer q.mu.Unlock()
+		return job.snapshot(), nil
+	}
+	q.mu.Unlock()
+
+	job, err := q.store.Get(id)
+	if err != nil {
+		return Job{}, err
+	}
+	return *job, nil
+}
+
+// Shutdown stop accepting jobs and wait for active ones until the context
+// is done, then the rest is canceled as on Close
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logrus.Warn("canceling unfinished jobs")
+	}
+	return q.close()
+}
+
+// Close cancel running attempts and stop retrying, unfinished jobs stay
+// pending in the store
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	return q.close()
+}
+
+func (q *Queue) close() error {
+	q.closeOnce.Do(func() {
+		q.cancel()
+		q.wg.Wait()
+		<-q.pruned
+	})
+	return nil
+}
+
+func (q *Queue) process(job *Job) {
+	defer q.wg.Done()
+	log := logrus.WithFields(logrus.Fields{
+		"job":     job.ID,
+		"service": job.Service,
+		"args":    job.Args,
+	})
+
+	backoff := q.opts.MinBackoff
+	for {
+		q.mu.Lock()
+		job.Status = StatusRunning
+		job.Attempts++
+		job.Updated = time.Now()
+		snapshot := job.snapshot()
+		q.mu.Unlock()
+		q.save(&snapshot)
+
+		err := q.run(q.ctx, job.Service, job.Args)
+		if q.ctx.Err() != nil {
+			q.abandon(job)
+			return
+		}
+		job.endAttempt(err)
+		if err == nil {
+			q.finish(job, StatusDone, nil)
+			return
+		}
+		if job.Attempts > q.opts.Retries || IsPermanent(err) {
+			log.WithError(err).Errorf("job failed after %d attempts", job.Attempts)
+			q.finish(job, StatusFailed, err)
+			return
+		}
+		log.WithError(err).Debugf("retry job in %s", backoff)
+
+		q.mu.Lock()
+		job.Status = StatusPending
+		job.Error = err.Error()
+		job.Updated = time.Now()
+		snapshot = job.snapshot()
+		q.mu.Unlock()
+		q.save(&snapshot)
+
+		select {
+		case <-time.After(backoff):
+		case <-q.ctx.Done():
+			q.abandon(job)
+			return
+		}
+		backoff *= 2
+		if backoff > q.opts.MaxBackoff {
+			backoff = q.opts.MaxBackoff
+		}
+	}
+}
+
+// abandon the job on close, it stays pending in the store to be resumed
+func (q *Queue) abandon(job *Job) {
+	q.mu.Lock()
+	job.Status = StatusPending
+	job.Updated = time.Now()
+	job.err = ErrClosed
+	snapshot := job.snapshot()
+	q.mu.Unlock()
+	q.release(job, &snapshot)
+	prom.JobFinished()
+	job.endAttempt(ErrClosed)
+	close(job.done)
+}
+
+func (q *Queue) finish(job *Job, status Status, err error) {
+	q.mu.Lock()
+	job.Status = status
+	job.Updated = time.Now()
+	job.err = err
+	job.Error = ""
+	if err != nil {
+		job.Error = err.Error()
+	}
+	snapshot := job.snapshot()
+	q.mu.Unlock()
+	q.release(job, &snapshot)
+	prom.JobFinished()
+	close(job.done)
+}
+
+// release save the final state of the job and only then drop it from the
+// active ones, so a new job with the same key is saved after it
+func (q *Queue) release(job *Job, snapshot *Job) {
+	q.save(snapshot)
+	q.mu.Lock()
+	delete(q.active, job.ID)
+	q.mu.Unlock()
+}
+
+// save persist the snapshot of the job, it's called without the lock since
+// the store may wait for the disk
+func (q *Queue) save(snapshot *Job) {
+	if err := q.store.Put(snapshot); err != nil {
+		logrus.WithError(err).WithField("job", snapshot.ID).Error("couldn't save job")
+	}
+}
@@ -0,0 +1,283 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueDeduplicate(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	q := NewQueue(context.Background(), nil, func(ctx context.Context, service, args string) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil
+	}, Options{})
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			job := q.Submit("ethHeaderCidByBlockNumber", `n: "123"`)
+			<-job.Done()
+			if job.Err() != nil {
+				t.Errorf("Want: nil, Got: %v", job.Err())
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Want: 1 call, Got: %d", calls)
+	}
+	job, err := q.Get(ID("ethHeaderCidByBlockNumber", `n: "123"`))
+	if err != nil || job.Status != StatusDone {
+		t.Errorf("Want: done, Got: %s, %v", job.Status, err)
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	errFill := errors.New("fill error")
+	var calls int32
+	q := NewQueue(context.Background(), nil, func(ctx context.Context, service, args string) error {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errFill
+		}
+		return nil
+	}, Options{Retries: 5, MinBackoff: time.Millisecond})
+
+	job := q.Submit("ethHeaderCidByBlockNumber", `n: "123"`)
+	<-job.Done()
+	if job.Err() != nil {
+		t.Errorf("Want: nil, Got: %v", job.Err())
+	}
+	if job.Attempts != 3 {
+		t.Errorf("Want: 3 attempts, Got: %d", job.Attempts)
+	}
+
+	q = NewQueue(context.Background(), nil, func(ctx context.Context, service, args string) error {
+		return errFill
+	}, Options{Retries: 2, MinBackoff: time.Millisecond})
+	job = q.Submit("ethHeaderCidByBlockNumber", `n: "123"`)
+	<-job.Done()
+	if !errors.Is(job.Err(), errFill) {
+		t.Errorf("Want: %v, Got: %v", errFill, job.Err())
+	}
+	if job.Status != StatusFailed || job.Attempts != 3 {
+		t.Errorf("Want: failed after 3 attempts, Got: %s after %d", job.Status, job.Attempts)
+	}
+}
+
+func TestQueueAttempted(t *testing.T) {
+	errFill := errors.New("fill error")
+	var calls int32
+	q := NewQueue(context.Background(), nil, func(ctx context.Context, service, args string) error {
+		if atomic.AddInt32(&calls, 1) < 2 {
+			return errFill
+		}
+		return nil
+	}, Options{Retries: 1, MinBackoff: 100 * time.Millisecond})
+	defer q.Close()
+
+	job := q.Submit("ethHeaderCidByBlockNumber", `n: "123"`)
+	<-job.Attempted()
+	if !errors.Is(job.AttemptErr(), errFill) {
+		t.Errorf("Want: %v, Got: %v", errFill, job.AttemptErr())
+	}
+	select {
+	case <-job.Done():
+		t.Error("Want: job is retried after the first attempt, Got: done")
+	default:
+	}
+	<-job.Done()
+	if job.Err() != nil {
+		t.Errorf("Want: nil after retry, Got: %v", job.Err())
+	}
+}
+
+func TestQueuePermanentError(t *testing.T) {
+	errFuture := errors.New("future block")
+	q := NewQueue(context.Background(), nil, func(ctx context.Context, service, args string) error {
+		return Permanent(errFuture)
+	}, Options{Retries: 5, MinBackoff: time.Millisecond})
+
+	job := q.Submit("ethHeaderCidByBlockNumber", `n: "123"`)
+	<-job.Done()
+	if !errors.Is(job.Err(), errFuture) {
+		t.Errorf("Want: %v, Got: %v", errFuture, job.Err())
+	}
+	if job.Attempts != 1 {
+		t.Errorf("Want: 1 attempt, Got: %d", job.Attempts)
+	}
+}
+
+func TestQueueResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := NewQueue(context.Background(), store, func(ctx context.Context, service, args string) error {
+		return errors.New("node is down")
+	}, Options{Retries: 10, MinBackoff: time.Hour})
+	job := q.Submit("ethHeaderCidByBlockNumber", `n: "123"`)
+	time.Sleep(50 * time.Millisecond)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-job.Done()
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan string, 1)
+	q = NewQueue(context.Background(), store, func(ctx context.Context, service, args string) error {
+		done <- service + "(" + args + ")"
+		return nil
+	}, Options{})
+	defer store.Close()
+	defer q.Close()
+	if err := q.Resume(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-done:
+		if got != `ethHeaderCidByBlockNumber(n: "123")` {
+			t.Errorf("Want: ethHeaderCidByBlockNumber(n: \"123\"), Got: %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Error("job was not resumed")
+	}
+}
+
+func TestQueueCloseCancel(t *testing.T) {
+	store := NewMemoryStore()
+	started := make(chan struct{})
+	q := NewQueue(context.Background(), store, func(ctx context.Context, service, args string) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}, Options{})
+
+	job := q.Submit("ethHeaderCidByBlockNumber", `n: "123"`)
+	<-started
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-job.Done()
+	if job.Err() != ErrClosed {
+		t.Errorf("Want: %v, Got: %v", ErrClosed, job.Err())
+	}
+	saved, err := store.Get(job.ID)
+	if err != nil || saved.Status != StatusPending {
+		t.Errorf("Want: pending job to resume, Got: %v, %v", saved, err)
+	}
+}
+
+func TestQueueShutdown(t *testing.T) {
+	q := NewQueue(context.Background(), nil, func(ctx context.Context, service, args string) error {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, Options{})
+
+	job := q.Submit("ethHeaderCidByBlockNumber", `n: "123"`)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-job.Done()
+	if job.Err() != nil {
+		t.Errorf("Want: running job is drained, Got: %v", job.Err())
+	}
+	if rejected := q.Submit("ethHeaderCidByBlockNumber", `n: "124"`); rejected.Err() != ErrClosed {
+		t.Errorf("Want: %v, Got: %v", ErrClosed, rejected.Err())
+	}
+}
+
+func TestQueueRetention(t *testing.T) {
+	store := NewMemoryStore()
+	q := NewQueue(context.Background(), store, func(ctx context.Context, service, args string) error {
+		return nil
+	}, Options{Retention: 20 * time.Millisecond})
+	defer q.Close()
+
+	job := q.Submit("ethHeaderCidByBlockNumber", `n: "123"`)
+	<-job.Done()
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := q.Get(job.ID)
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Want: finished job pruned, Got: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type slowStore struct {
+	*MemoryStore
+	release chan struct{}
+}
+
+func (store slowStore) Put(job *Job) error {
+	<-store.release
+	return store.MemoryStore.Put(job)
+}
+
+func TestQueueSlowStore(t *testing.T) {
+	store := slowStore{NewMemoryStore(), make(chan struct{})}
+	q := NewQueue(context.Background(), store, func(ctx context.Context, service, args string) error {
+		return nil
+	}, Options{})
+
+	submitted := make(chan *Job)
+	go func() {
+		submitted <- q.Submit("ethHeaderCidByBlockNumber", `n: "123"`)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := q.Get(ID("ethHeaderCidByBlockNumber", `n: "123"`))
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Errorf("Want: nil, Got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Want: the queue isn't locked while saving, Got: blocked")
+	}
+
+	close(store.release)
+	job := <-submitted
+	<-job.Done()
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := store.Get(job.ID)
+	if err != nil || saved.Status != StatusDone {
+		t.Errorf("Want: done, Got: %v, %v", saved, err)
+	}
+}
@@ -0,0 +1,139 @@
+package jobs
+
+import (
+	"encoding/json"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucket = []byte("jobs")
+
+// Store keeps the state of jobs
+type Store interface {
+	Put(job *Job) error
+	Get(id string) (*Job, error)
+	Delete(id string) error
+	All() ([]*Job, error)
+	Close() error
+}
+
+// MemoryStore keeps jobs in memory, they are lost on restart
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// NewMemoryStore create new in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]Job)}
+}
+
+func (store *MemoryStore) Put(job *Job) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.jobs[job.ID] = job.snapshot()
+	return nil
+}
+
+func (store *MemoryStore) Get(id string) (*Job, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	job, ok := store.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
+
+func (store *MemoryStore) Delete(id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.jobs, id)
+	return nil
+}
+
+func (store *MemoryStore) All() ([]*Job, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	jobs := make([]*Job, 0, len(store.jobs))
+	for id := range store.jobs {
+		job := store.jobs[id]
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
+
+// BoltStore keeps jobs in BoltDB file, so pending jobs survive a restart
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore open or create BoltDB file
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (store *BoltStore) Put(job *Job) error {
+	data, err := json.Marshal(job.snapshot())
+	if err != nil {
+		return err
+	}
+	// concurrent puts share a transaction and its fsync
+	return store.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(job.ID), data)
+	})
+}
+
+func (store *BoltStore) Get(id string) (*Job, error) {
+	var job *Job
+	err := store.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &job)
+	})
+	return job, err
+}
+
+func (store *BoltStore) Delete(id string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(id))
+	})
+}
+
+func (store *BoltStore) All() ([]*Job, error) {
+	jobs := make([]*Job, 0)
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, &job)
+			return nil
+		})
+	})
+	return jobs, err
+}
+
+func (store *BoltStore) Close() error {
+	return store.db.Close()
+}
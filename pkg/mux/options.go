@@ -1,25 +1,65 @@
 package mux
 
 import (
+	"context"
 	"net/url"
+	"time"
 
-	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/vulcanize/gap-filler/pkg/auth"
+	"github.com/vulcanize/gap-filler/pkg/jobs"
+	"github.com/vulcanize/gap-filler/pkg/proxy"
+	"github.com/vulcanize/gap-filler/pkg/qlservices"
+	"github.com/vulcanize/gap-filler/pkg/rpcpool"
 )
 
 type PostgraphileOptions struct {
 	Default    *url.URL
 	TracingAPI *url.URL
+	Upstreams  map[string]*url.URL
+	Routes     []proxy.Route
 }
 
 type RPCOptions struct {
-	DefaultClients []*rpc.Client
-	TracingClients []*rpc.Client
+	Default *rpcpool.Pool
+	Tracing *rpcpool.Pool
+}
+
+type JobsOptions struct {
+	Store jobs.Store
+	Queue jobs.Options
+}
+
+type NotifyOptions struct {
+	Notifier    proxy.Notifier
+	Fallback    time.Duration
+	MinInterval time.Duration
+}
+
+type AuthOptions struct {
+	// Authenticator is required for /graphql and /gapfill/jobs, the
+	// endpoints are open when it's nil
+	Authenticator auth.Authenticator
+	// Forward passes the Authorization header of the client to postgraphile
+	Forward bool
 }
 
 // Options configurations for proxy service
 type Options struct {
+	// Context is the server lifetime, gap-fills are canceled when it's done
+	Context        context.Context
 	BasePath       string
 	EnableGraphiQL bool
 	Postgraphile   PostgraphileOptions
 	RPC            RPCOptions
+	Services       []qlservices.ServiceConfig
+	RangeWorkers   int
+	Jobs           JobsOptions
+	Notify         NotifyOptions
+	Auth           AuthOptions
+	Limits         proxy.LimitsOptions
+	Cache          proxy.CacheOptions
+	Failures       proxy.FailuresOptions
+	Persisted      proxy.PersistedOptions
+	Headers        proxy.HeadersOptions
+	Schema         proxy.SchemaOptions
 }
@@ -1,15 +1,29 @@
 package mux
 
 import (
+	"context"
 	"net/http"
 	"path"
 
 	"github.com/friendsofgo/graphiql"
+	"github.com/vulcanize/gap-filler/pkg/auth"
 	"github.com/vulcanize/gap-filler/pkg/proxy"
 )
 
+// ServeMux routes gap-filler endpoints
+type ServeMux struct {
+	*http.ServeMux
+	proxy *proxy.Proxy
+}
+
+// Shutdown drain gap-fill jobs, it must be called after the http server is
+// shut down
+func (mux *ServeMux) Shutdown(ctx context.Context) error {
+	return mux.proxy.Shutdown(ctx)
+}
+
 // NewServeMux create new http service
-func NewServeMux(opts *Options) (*http.ServeMux, error) {
+func NewServeMux(opts *Options) (*ServeMux, error) {
 	mux := http.NewServeMux()
 
 	if opts.EnableGraphiQL {
@@ -20,16 +34,65 @@ func NewServeMux(opts *Options) (*http.ServeMux, error) {
 		mux.Handle(path.Join(opts.BasePath, "/graphiql"), grphiql)
 	}
 
-	mux.Handle(path.Join(opts.BasePath, "/graphql"), proxy.New(&proxy.Options{
+	prx, err := proxy.New(&proxy.Options{
+		Context: opts.Context,
 		RPC: proxy.RPCOptions{
-			DefaultClients: opts.RPC.DefaultClients,
-			TracingClients: opts.RPC.TracingClients,
+			Default: opts.RPC.Default,
+			Tracing: opts.RPC.Tracing,
 		},
 		Postgraphile: proxy.PostgraphileOptions{
 			Default:    opts.Postgraphile.Default,
 			TracingAPI: opts.Postgraphile.TracingAPI,
+			Upstreams:  opts.Postgraphile.Upstreams,
+			Routes:     opts.Postgraphile.Routes,
+		},
+		Services:     opts.Services,
+		RangeWorkers: opts.RangeWorkers,
+		Jobs: proxy.JobsOptions{
+			Store: opts.Jobs.Store,
+			Queue: opts.Jobs.Queue,
+		},
+		Notify: proxy.NotifyOptions{
+			Notifier:    opts.Notify.Notifier,
+			Fallback:    opts.Notify.Fallback,
+			MinInterval: opts.Notify.MinInterval,
 		},
-	}))
+		ForwardAuthorization: opts.Auth.Forward,
+		Limits:               opts.Limits,
+		Cache:                opts.Cache,
+		Failures:             opts.Failures,
+		Persisted:            opts.Persisted,
+		Headers:              opts.Headers,
+		Schema:               opts.Schema,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var graphql, jobs, failures, schema http.Handler = prx, prx.JobsHandler(), prx.FailuresHandler(), prx.SchemaHandler()
+	if opts.Auth.Authenticator != nil {
+		if !opts.Auth.Forward {
+			graphql = stripCredentials(graphql)
+		}
+		graphql = auth.Middleware(opts.Auth.Authenticator, graphql)
+		jobs = auth.Middleware(opts.Auth.Authenticator, jobs)
+		failures = auth.Middleware(opts.Auth.Authenticator, failures)
+		schema = auth.Middleware(opts.Auth.Authenticator, schema)
+	}
+	mux.Handle(path.Join(opts.BasePath, "/graphql"), graphql)
+	mux.Handle(path.Join(opts.BasePath, "/gapfill/jobs")+"/", jobs)
+	mux.Handle(path.Join(opts.BasePath, "/gapfill/failures"), failures)
+	mux.Handle(path.Join(opts.BasePath, "/gapfill/schema"), schema)
+
+	return &ServeMux{ServeMux: mux, proxy: prx}, nil
+}
 
-	return mux, nil
+// stripCredentials keeps gap-filler credentials from postgraphile, websocket
+// upgrades are proxied with all the headers of the client
+func stripCredentials(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+		r.Header.Del("Authorization")
+		r.Header.Del(auth.KeyHeader)
+		next.ServeHTTP(w, r)
+	})
 }
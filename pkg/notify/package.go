@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"sync"
+)
+
+// Broadcaster wakes up every subscriber on notification. Notifications are
+// coalesced, a subscriber which hasn't handled the previous one gets a single
+// wake up
+type Broadcaster struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+// NewBroadcaster create broadcaster without subscribers
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subs: make(map[chan struct{}]struct{})}
+}
+
+// Subscribe returns the channel of notifications and the func to unsubscribe
+func (b *Broadcaster) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// Notify wake up all subscribers
+func (b *Broadcaster) Notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
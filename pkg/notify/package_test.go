@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBroadcaster(t *testing.T) {
+	b := NewBroadcaster()
+	ch1, cancel1 := b.Subscribe()
+	ch2, cancel2 := b.Subscribe()
+	defer cancel2()
+
+	b.Notify()
+	b.Notify()
+	for i, ch := range []<-chan struct{}{ch1, ch2} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("Want: subscriber %d is notified, Got: nothing", i)
+		}
+		select {
+		case <-ch:
+			t.Errorf("Want: notifications of subscriber %d are coalesced, Got: second one", i)
+		default:
+		}
+	}
+
+	cancel1()
+	b.Notify()
+	select {
+	case <-ch1:
+		t.Error("Want: unsubscribed channel isn't notified, Got: notification")
+	case <-ch2:
+	}
+}
@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultChannel is the postgres channel used when Options.Channel is empty
+const DefaultChannel = "gap_filler"
+
+// Tables which inserts wake up polling
+var Tables = []string{"eth.header_cids", "eth.transaction_cids"}
+
+// Options of the postgres listener
+type Options struct {
+	// Channel is the postgres notification channel
+	Channel string
+	// MinReconnect is the delay before reconnecting after a connection loss
+	MinReconnect time.Duration
+	// MaxReconnect limits the delay between reconnects
+	MaxReconnect time.Duration
+}
+
+func (opts *Options) defaults() {
+	if opts.Channel == "" {
+		opts.Channel = DefaultChannel
+	}
+	if opts.MinReconnect <= 0 {
+		opts.MinReconnect = time.Second
+	}
+	if opts.MaxReconnect < opts.MinReconnect {
+		opts.MaxReconnect = 30 * opts.MinReconnect
+	}
+}
+
+// Listener LISTENs the postgres channel and broadcasts notifications. Every
+// reconnect is broadcast as well, since notifications could be lost meanwhile
+type Listener struct {
+	*Broadcaster
+	listener *pq.Listener
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// Listen connect to postgres and start listening the channel
+func Listen(dsn string, opts Options) (*Listener, error) {
+	opts.defaults()
+	pql := pq.NewListener(dsn, opts.MinReconnect, opts.MaxReconnect, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventConnectionAttemptFailed, pq.ListenerEventDisconnected:
+			logrus.WithError(err).Warn("postgres listener connection lost")
+		case pq.ListenerEventReconnected:
+			logrus.Info("postgres listener reconnected")
+		}
+	})
+	if err := pql.Listen(opts.Channel); err != nil {
+		pql.Close()
+		return nil, err
+	}
+
+	l := &Listener{
+		Broadcaster: NewBroadcaster(),
+		listener:    pql,
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+func (l *Listener) run() {
+	defer close(l.done)
+	for {
+		select {
+		case n := <-l.listener.Notify:
+			// nil is sent after reconnect
+			if n != nil {
+				logrus.WithField("table", n.Extra).Debug("postgres notification")
+			}
+			l.Notify()
+		case <-l.quit:
+			return
+		}
+	}
+}
+
+// Close stop listening
+func (l *Listener) Close() error {
+	close(l.quit)
+	<-l.done
+	return l.listener.Close()
+}
+
+// InstallTriggers create statement level triggers which notify the channel
+// about inserts into Tables, the payload is the table name
+func InstallTriggers(dsn string, channel string) error {
+	if channel == "" {
+		channel = DefaultChannel
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE OR REPLACE FUNCTION public.gap_filler_notify() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify(TG_ARGV[0], TG_TABLE_NAME);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql`)
+	if err != nil {
+		return err
+	}
+	for _, table := range Tables {
+		_, err = db.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS gap_filler_notify ON %[1]s;
+CREATE TRIGGER gap_filler_notify AFTER INSERT ON %[1]s
+	FOR EACH STATEMENT EXECUTE PROCEDURE public.gap_filler_notify(%[2]s)`, table, pq.QuoteLiteral(channel)))
+		if err != nil {
+			return fmt.Errorf("%s trigger: %w", table, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package prom
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gap_filler"
+
+var (
+	metrics bool
+	once    sync.Once
+
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of graphql requests by query name",
+	}, []string{"query"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of graphql requests by query name",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 15, 30},
+	}, []string{"query"})
+	emptyResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "service",
+		Name:      "empty_responses_total",
+		Help:      "Number of empty postgraphile responses by service",
+	}, []string{"service"})
+	fills = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "service",
+		Name:      "fills_total",
+		Help:      "Number of Service.Do calls by service and status",
+	}, []string{"service", "status"})
+	rpcCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "calls_total",
+		Help:      "Number of rpc calls by method, backend and status",
+	}, []string{"method", "backend", "status"})
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "call_duration_seconds",
+		Help:      "Latency of rpc calls by method and backend",
+	}, []string{"method", "backend"})
+	backendHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "backend_healthy",
+		Help:      "Whether the rpc backend passes health checks",
+	}, []string{"backend"})
+	pollingIterations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "polling",
+		Name:      "iterations_total",
+		Help:      "Number of postgraphile requests made while polling",
+	})
+	pollingTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "polling",
+		Name:      "timeouts_total",
+		Help:      "Number of polls which didn't get the data in time",
+	})
+	jobsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "jobs",
+		Name:      "in_flight",
+		Help:      "Number of active gap-fill jobs",
+	})
+	fillsLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "service",
+		Name:      "fills_limited_total",
+		Help:      "Number of gap-fills rejected or delayed by limits, by reason and action",
+	}, []string{"reason", "action"})
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "lookups_total",
+		Help:      "Number of response cache lookups by service and result, hit or miss",
+	}, []string{"service", "result"})
+)
+
+// Init enable and register metrics
+func Init() {
+	once.Do(func() {
+		metrics = true
+		prometheus.MustRegister(
+			requests,
+			requestDuration,
+			emptyResponses,
+			fills,
+			rpcCalls,
+			rpcDuration,
+			backendHealthy,
+			pollingIterations,
+			pollingTimeouts,
+			jobsInFlight,
+			fillsLimited,
+			cacheLookups,
+		)
+	})
+}
+
+// Handler serve registered metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func status(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// Request count the graphql request and its latency
+func Request(query string, duration time.Duration) {
+	if !metrics {
+		return
+	}
+	requests.WithLabelValues(query).Inc()
+	requestDuration.WithLabelValues(query).Observe(duration.Seconds())
+}
+
+// EmptyResponse count empty postgraphile response of the service
+func EmptyResponse(service string) {
+	if metrics {
+		emptyResponses.WithLabelValues(service).Inc()
+	}
+}
+
+// Fill count Service.Do result
+func Fill(service string, err error) {
+	if metrics {
+		fills.WithLabelValues(service, status(err)).Inc()
+	}
+}
+
+// RPCCall count rpc call result and its latency
+func RPCCall(method, backend string, duration time.Duration, err error) {
+	if !metrics {
+		return
+	}
+	rpcCalls.WithLabelValues(method, backend, status(err)).Inc()
+	rpcDuration.WithLabelValues(method, backend).Observe(duration.Seconds())
+}
+
+// BackendHealthy set health state of the rpc backend
+func BackendHealthy(backend string, healthy bool) {
+	if !metrics {
+		return
+	}
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	backendHealthy.WithLabelValues(backend).Set(value)
+}
+
+// PollingIteration count postgraphile request made by polling
+func PollingIteration() {
+	if metrics {
+		pollingIterations.Inc()
+	}
+}
+
+// PollingTimeout count polling timeout
+func PollingTimeout() {
+	if metrics {
+		pollingTimeouts.Inc()
+	}
+}
+
+// JobStarted increase number of active jobs
+func JobStarted() {
+	if metrics {
+		jobsInFlight.Inc()
+	}
+}
+
+// JobFinished decrease number of active jobs
+func JobFinished() {
+	if metrics {
+		jobsInFlight.Dec()
+	}
+}
+
+// FillLimited count gap-fill hit the limit, action is queued or rejected
+func FillLimited(reason, action string) {
+	if metrics {
+		fillsLimited.WithLabelValues(reason, action).Inc()
+	}
+}
+
+// CacheLookup count response cache lookup of the service
+func CacheLookup(service string, hit bool) {
+	if metrics {
+		result := "miss"
+		if hit {
+			result = "hit"
+		}
+		cacheLookups.WithLabelValues(service, result).Inc()
+	}
+}
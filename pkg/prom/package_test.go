@@ -0,0 +1,52 @@
+package prom
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	// nothing is recorded until metrics are enabled
+	Fill("ethHeaderCidByBlockNumber", nil)
+	if got := testutil.ToFloat64(fills.WithLabelValues("ethHeaderCidByBlockNumber", "success")); got != 0 {
+		t.Errorf("Want: 0 before Init, Got: %v", got)
+	}
+
+	Init()
+	Init()
+	Fill("ethHeaderCidByBlockNumber", nil)
+	Fill("ethHeaderCidByBlockNumber", errors.New("rpc error"))
+	RPCCall("statediff_writeStateDiffAt", "http://127.0.0.1:8545", time.Millisecond, nil)
+	BackendHealthy("http://127.0.0.1:8545", false)
+	CacheLookup("ethHeaderCidByBlockNumber", true)
+	JobStarted()
+	JobStarted()
+	JobFinished()
+
+	for i, tc := range []struct {
+		got  float64
+		want float64
+	}{
+		{testutil.ToFloat64(fills.WithLabelValues("ethHeaderCidByBlockNumber", "success")), 1},
+		{testutil.ToFloat64(fills.WithLabelValues("ethHeaderCidByBlockNumber", "failure")), 1},
+		{testutil.ToFloat64(rpcCalls.WithLabelValues("statediff_writeStateDiffAt", "http://127.0.0.1:8545", "success")), 1},
+		{testutil.ToFloat64(backendHealthy.WithLabelValues("http://127.0.0.1:8545")), 0},
+		{testutil.ToFloat64(cacheLookups.WithLabelValues("ethHeaderCidByBlockNumber", "hit")), 1},
+		{testutil.ToFloat64(jobsInFlight), 1},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("[%d] Want: %v, Got: %v", i, tc.want, tc.got)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rr.Body.String(), `gap_filler_service_fills_total{service="ethHeaderCidByBlockNumber",status="success"} 1`) {
+		t.Errorf("Want: fills in the exposition, Got: %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/valyala/fastjson"
+	"github.com/vulcanize/gap-filler/pkg/cache"
+	"github.com/vulcanize/gap-filler/pkg/qlparser"
+)
+
+// CacheHeader of the response tells how service queries were served: hit,
+// miss, partial or bypass
+const CacheHeader = "X-Gapfill-Cache"
+
+type CacheOptions struct {
+	// Cache is optional, responses aren't cached when it's nil
+	Cache cache.Cache
+	// Finality is the depth behind the chain head after which blocks are
+	// treated as immutable, only their responses are cached
+	Finality uint64
+	// MaxAge of GET responses of final blocks in Cache-Control
+	MaxAge time.Duration
+}
+
+// cacheControl of the request. `Cache-Control: no-cache` skips lookups,
+// `no-store` skips both lookups and stores
+func cacheControl(r *http.Request) (lookup, store bool) {
+	lookup, store = true, true
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-cache":
+			lookup = false
+		case "no-store":
+			lookup, store = false, false
+		}
+	}
+	return
+}
+
+func cacheStatus(lookup bool, hits, total int) string {
+	switch {
+	case !lookup:
+		return "bypass"
+	case hits == 0:
+		return "miss"
+	case hits < total:
+		return "partial"
+	}
+	return "hit"
+}
+
+// cacheKey of the split document, it's the same for documents which differ
+// only in formatting or unused variables. The upstream and forwarded headers
+// are a part of the key since postgraphile may answer differently per user
+func cacheKey(upstream, name string, doc []byte, header http.Header) (string, error) {
+	normalized, err := qlparser.Normalize(doc)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write([]byte(upstream))
+	hash.Write([]byte{0})
+	hash.Write([]byte(name))
+	hash.Write([]byte{0})
+	hash.Write(varyingHeaders(header))
+	hash.Write([]byte{0})
+	hash.Write(normalized)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// cacheable reports whether the response is complete and all its blocks are
+// final. Responses without blockNumber fields can't be checked, so they
+// aren't cached
+func (handler *HTTPReverseProxy) cacheable(srv Service, args []*ast.Argument, data []byte) bool {
+	result, err := fastjson.ParseBytes(data)
+	if err != nil || result.Exists("errors") {
+		return false
+	}
+	if rangeSrv, ok := srv.(RangeService); ok {
+		if missing, err := rangeSrv.Missing(args, data); err != nil || len(missing) > 0 {
+			return false
+		}
+	} else if empty, err := srv.IsEmpty(data); err != nil || empty {
+		return false
+	}
+
+	latest, found := maxBlockNumber(result.Get("data"))
+	if !found {
+		return false
+	}
+	head := handler.head()
+	return head != 0 && latest+handler.finality <= head
+}
+
+// maxBlockNumber find the highest `blockNumber` field of the response
+func maxBlockNumber(value *fastjson.Value) (max uint64, found bool) {
+	if value == nil {
+		return 0, false
+	}
+	switch value.Type() {
+	case fastjson.TypeObject:
+		value.GetObject().Visit(func(key []byte, v *fastjson.Value) {
+			var n uint64
+			var ok bool
+			if string(key) == "blockNumber" {
+				n, ok = parseBlockNumber(v)
+			} else {
+				n, ok = maxBlockNumber(v)
+			}
+			if ok {
+				found = true
+				if n > max {
+					max = n
+				}
+			}
+		})
+	case fastjson.TypeArray:
+		for _, item := range value.GetArray() {
+			if n, ok := maxBlockNumber(item); ok {
+				found = true
+				if n > max {
+					max = n
+				}
+			}
+		}
+	}
+	return max, found
+}
+
+// parseBlockNumber of postgraphile BigInt, it's a decimal string
+func parseBlockNumber(value *fastjson.Value) (uint64, bool) {
+	switch value.Type() {
+	case fastjson.TypeString:
+		n, err := strconv.ParseUint(string(value.GetStringBytes()), 10, 64)
+		return n, err == nil
+	case fastjson.TypeNumber:
+		n, err := value.Uint64()
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/vulcanize/gap-filler/pkg/auth"
+)
+
+// ErrKnownFailure is returned instead of the gap-fill which failed recently
+var ErrKnownFailure = errors.New("gap-fill failed recently")
+
+// FailureCategory tells why the gap-fill failed, memoized failures of the
+// category are kept for its TTL
+type FailureCategory string
+
+// List of categories, transport errors, cancellations and polling timeouts
+// aren't memoized
+const (
+	// FailureNotFound the node doesn't know the block
+	FailureNotFound FailureCategory = "block_not_found"
+	// FailureUnavailable the state of the block is pruned
+	FailureUnavailable FailureCategory = "state_unavailable"
+	// FailureRPC any other json-rpc error
+	FailureRPC FailureCategory = "rpc_error"
+)
+
+type FailuresOptions struct {
+	// TTL of memoized failures by category, categories without TTL aren't memoized
+	TTL map[FailureCategory]time.Duration
+	// AllowClear lets unauthenticated requests clear failures, for deployments
+	// without authentication where the proxy isn't exposed publicly
+	AllowClear bool
+}
+
+// Failure is the memoized gap-fill failure of the service for the arguments
+type Failure struct {
+	Service  string          `json:"service"`
+	Args     string          `json:"args"`
+	Category FailureCategory `json:"category"`
+	Error    string          `json:"error"`
+	Until    time.Time       `json:"until"`
+}
+
+func (f *Failure) err() error {
+	return fmt.Errorf("%w (%s, retry after %s): %s", ErrKnownFailure, f.Category, f.Until.UTC().Format(time.RFC3339), f.Error)
+}
+
+type failureKey struct {
+	service string
+	args    string
+}
+
+// failures memoizes gap-fill failures which are likely to repeat
+type failures struct {
+	ttl        map[FailureCategory]time.Duration
+	allowClear bool
+
+	mu    sync.Mutex
+	items map[failureKey]*Failure
+	swept time.Time
+}
+
+func newFailures(opts FailuresOptions) *failures {
+	return &failures{
+		ttl:        opts.TTL,
+		allowClear: opts.AllowClear,
+		items:      make(map[failureKey]*Failure),
+		swept:      time.Now(),
+	}
+}
+
+// categorize the error, it's empty if the failure shouldn't be memoized
+func categorize(err error) FailureCategory {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return ""
+	}
+	msg := strings.ToLower(rpcErr.Error())
+	switch {
+	case strings.Contains(msg, "missing trie node"),
+		strings.Contains(msg, "pruned"),
+		strings.Contains(msg, "historical state"),
+		strings.Contains(msg, "state not available"):
+		return FailureUnavailable
+	case strings.Contains(msg, "not found"), strings.Contains(msg, "no block"):
+		return FailureNotFound
+	}
+	return FailureRPC
+}
+
+// check returns the memoized failure as ErrKnownFailure
+func (fs *failures) check(service, args string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	key := failureKey{service, args}
+	f, ok := fs.items[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(f.Until) {
+		delete(fs.items, key)
+		return nil
+	}
+	return f.err()
+}
+
+// record the failure if its category has TTL
+func (fs *failures) record(service, args string, err error) {
+	category := categorize(err)
+	ttl := fs.ttl[category]
+	if category == "" || ttl <= 0 {
+		return
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	now := time.Now()
+	if now.Sub(fs.swept) > time.Minute {
+		for key, f := range fs.items {
+			if now.After(f.Until) {
+				delete(fs.items, key)
+			}
+		}
+		fs.swept = now
+	}
+	fs.items[failureKey{service, args}] = &Failure{
+		Service:  service,
+		Args:     args,
+		Category: category,
+		Error:    err.Error(),
+		Until:    now.Add(ttl),
+	}
+}
+
+// list active failures ordered by service and args
+func (fs *failures) list() []Failure {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	now := time.Now()
+	list := make([]Failure, 0, len(fs.items))
+	for _, f := range fs.items {
+		if now.Before(f.Until) {
+			list = append(list, *f)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Service != list[j].Service {
+			return list[i].Service < list[j].Service
+		}
+		return list[i].Args < list[j].Args
+	})
+	return list
+}
+
+// clear failures of the service, all services if it's empty, and of the
+// arguments, all arguments if they're empty. It returns the number of
+// cleared failures
+func (fs *failures) clear(service, args string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	cleared := 0
+	for key := range fs.items {
+		if service != "" && key.service != service || args != "" && key.args != args {
+			continue
+		}
+		delete(fs.items, key)
+		cleared++
+	}
+	return cleared
+}
+
+// FailuresHandler serve memoized gap-fill failures. GET lists them, DELETE
+// clears them optionally filtered by `service` and `args` query parameters.
+// Authenticated clients must be admins, DELETE is refused without
+// authentication unless it's allowed by the options
+func (handler *HTTPReverseProxy) FailuresHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok := auth.FromContext(r.Context())
+		if ok && !client.Admin || !ok && r.Method == http.MethodDelete && !handler.failures.allowClear {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(handler.failures.list())
+		case http.MethodDelete:
+			query := r.URL.Query()
+			cleared := handler.failures.clear(query.Get("service"), query.Get("args"))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]int{"cleared": cleared})
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
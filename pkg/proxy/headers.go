@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// RequestIDHeader identifies the client request in postgraphile requests and
+// logs, it's generated when the client doesn't send one
+const RequestIDHeader = "X-Request-Id"
+
+// DefaultForwardHeaders are passed to postgraphile when no allowlist is set
+var DefaultForwardHeaders = []string{"Cookie", RequestIDHeader}
+
+// headers of the connection and the body are owned by the postgraphile
+// request, they are never forwarded
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Host",
+	"Content-Length",
+	"Content-Type",
+	"Content-Encoding",
+	"Accept-Encoding",
+}
+
+// identity headers differ per request, they don't change the response
+var identityHeaders = []string{RequestIDHeader, "X-Forwarded-For"}
+
+type HeadersOptions struct {
+	// Allow headers of the client which are passed to postgraphile,
+	// DefaultForwardHeaders when it's empty, "*" allows all of them
+	Allow []string
+	// Deny headers of the client, it takes precedence over Allow
+	Deny []string
+	// Static headers are set on every postgraphile request, they replace
+	// headers of the client
+	Static map[string]string
+}
+
+// headerPolicy builds headers of postgraphile requests from the client request
+type headerPolicy struct {
+	all    bool
+	allow  map[string]bool
+	deny   map[string]bool
+	static http.Header
+}
+
+// newHeaderPolicy of the options, forwardAuth allows Authorization
+func newHeaderPolicy(opts HeadersOptions, forwardAuth bool) *headerPolicy {
+	allow := opts.Allow
+	if len(allow) == 0 {
+		allow = DefaultForwardHeaders
+	}
+	policy := &headerPolicy{
+		allow:  make(map[string]bool),
+		deny:   make(map[string]bool),
+		static: make(http.Header),
+	}
+	for _, name := range allow {
+		if name == "*" {
+			policy.all = true
+			continue
+		}
+		policy.allow[http.CanonicalHeaderKey(name)] = true
+	}
+	if forwardAuth {
+		policy.allow["Authorization"] = true
+	}
+	for _, name := range append(hopHeaders, opts.Deny...) {
+		policy.deny[http.CanonicalHeaderKey(name)] = true
+	}
+	for name, value := range opts.Static {
+		policy.static.Set(name, value)
+	}
+	return policy
+}
+
+func (policy *headerPolicy) allowed(name string) bool {
+	return !policy.deny[name] && (policy.all || policy.allow[name])
+}
+
+// outgoing headers of postgraphile requests made on behalf of the request.
+// The request id and the client address in X-Forwarded-For are always
+// passed unless they are denied
+func (policy *headerPolicy) outgoing(r *http.Request) http.Header {
+	header := make(http.Header)
+	for name, values := range r.Header {
+		if policy.allowed(name) {
+			header[name] = append([]string(nil), values...)
+		}
+	}
+
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		header.Set(RequestIDHeader, id)
+	} else {
+		header.Set(RequestIDHeader, newRequestID())
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		// the values are copied, appending to them could write into the
+		// backing array of the incoming request
+		var forwarded []string
+		if policy.allowed("X-Forwarded-For") {
+			forwarded = append(forwarded, r.Header.Values("X-Forwarded-For")...)
+		}
+		header.Set("X-Forwarded-For", strings.Join(append(forwarded, host), ", "))
+	}
+	for _, name := range identityHeaders {
+		if policy.deny[name] {
+			header.Del(name)
+		}
+	}
+
+	for name, values := range policy.static {
+		header[name] = values
+	}
+	return header
+}
+
+// varyingHeaders of the outgoing request which may change the response of
+// postgraphile, e.g. row-level security depends on them
+func varyingHeaders(header http.Header) []byte {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf []byte
+	for _, name := range names {
+		if name == RequestIDHeader || name == "X-Forwarded-For" {
+			continue
+		}
+		for _, value := range header[name] {
+			buf = append(buf, name...)
+			buf = append(buf, ':')
+			buf = append(buf, value...)
+			buf = append(buf, 0)
+		}
+	}
+	return buf
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -2,36 +2,93 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/graphql-go/graphql/language/ast"
 	"github.com/sirupsen/logrus"
 	"github.com/valyala/fastjson"
+	"github.com/vulcanize/gap-filler/pkg/auth"
+	"github.com/vulcanize/gap-filler/pkg/cache"
+	"github.com/vulcanize/gap-filler/pkg/jobs"
+	"github.com/vulcanize/gap-filler/pkg/prom"
 	"github.com/vulcanize/gap-filler/pkg/qlparser"
+	"github.com/vulcanize/gap-filler/pkg/qlservices"
+	"github.com/vulcanize/gap-filler/pkg/schema"
+)
+
+// List of errors
+var (
+	ErrUnknownService = errors.New("unknown service")
+	ErrPollingTimeout = errors.New("polling timeout")
+	ErrFillForbidden  = errors.New("gap-fill isn't allowed for the client")
+)
+
+// Async fill mode is chosen by the header or the directive on the operation
+// or root field. In async mode the proxy doesn't wait for the gap-fill, it
+// responds at once with submitted jobs in `extensions.gapFiller`
+const (
+	ModeHeader     = "X-Gapfill-Mode"
+	ModeAsync      = "async"
+	AsyncDirective = "async"
 )
 
 type Service interface {
 	Name() string
 	Validate(args []*ast.Argument) error
 	IsEmpty(data []byte) (bool, error)
-	Do(args []*ast.Argument) error
+	// Do fill the gap, the call is canceled with the context
+	Do(ctx context.Context, args []*ast.Argument) error
+}
+
+// RangeService is a Service answering block range connection queries,
+// it is able to fill only the missing heights of the range
+type RangeService interface {
+	Service
+	Missing(args []*ast.Argument, data []byte) ([]uint64, error)
+	DoAt(ctx context.Context, n uint64) error
+}
+
+// Upstream is implemented by services which choose the postgraphile endpoint themselves
+type Upstream interface {
+	Postgraphile() string
 }
 
 // HTTPReverseProxy it work with a regular HTTP request
 type HTTPReverseProxy struct {
-	pqlDefault   *url.URL
-	pqlTracing   *url.URL
-	client       *http.Client
-	forward      func(uri *url.URL, body []byte) ([]byte, error)
-	polling      func(r *http.Request, uri *url.URL, body []byte, names []string) ([]byte, error)
-	mu           sync.Mutex
-	serviceNames []string
-	services     map[string]Service
+	router         *router
+	schema         *unifiedSchema
+	client         *http.Client
+	forward        func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error)
+	polling        func(ctx context.Context, uri *url.URL, body []byte, isEmpty func(data []byte) (bool, error)) ([]byte, error)
+	notifier       Notifier
+	notifyFallback time.Duration
+	notifyMin      time.Duration
+	headers        *headerPolicy
+	limits         *limits
+	failures       *failures
+	cache          cache.Cache
+	finality       uint64
+	cacheMaxAge    time.Duration
+	persistedStore cache.Cache
+	head           func() uint64
+	rangeWorkers   int
+	jobs           *jobs.Queue
+	mu             sync.Mutex
+	serviceNames   []string
+	services       map[string]Service
 }
 
 // NewHTTPReverseProxy create new http-proxy-handler
@@ -39,77 +96,153 @@ func NewHTTPReverseProxy(opts *Options) *HTTPReverseProxy {
 	client := &http.Client{
 		Timeout: 15 * time.Second,
 	}
+	if opts.Notify.Fallback <= 0 {
+		opts.Notify.Fallback = 2 * time.Second
+	}
+	if opts.Notify.MinInterval <= 0 {
+		opts.Notify.MinInterval = 100 * time.Millisecond
+	}
+	rangeWorkers := opts.RangeWorkers
+	if rangeWorkers <= 0 {
+		rangeWorkers = 1
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	proxy := HTTPReverseProxy{
-		pqlDefault:   opts.Postgraphile.Default,
-		pqlTracing:   opts.Postgraphile.TracingAPI,
-		client:       client,
-		serviceNames: make([]string, 0),
-		services:     make(map[string]Service),
-	}
-	proxy.forward = func(uri *url.URL, body []byte) ([]byte, error) {
-		req, err := http.NewRequest("POST", uri.String(), bytes.NewReader(body))
+		router:         newRouter(opts.Postgraphile),
+		schema:         newUnifiedSchema(ctx, opts.Schema),
+		client:         client,
+		rangeWorkers:   rangeWorkers,
+		notifier:       opts.Notify.Notifier,
+		notifyFallback: opts.Notify.Fallback,
+		notifyMin:      opts.Notify.MinInterval,
+		headers:        newHeaderPolicy(opts.Headers, opts.ForwardAuthorization),
+		limits:         newLimits(opts.Limits),
+		failures:       newFailures(opts.Failures),
+		cache:          opts.Cache.Cache,
+		finality:       opts.Cache.Finality,
+		cacheMaxAge:    opts.Cache.MaxAge,
+		persistedStore: opts.Persisted.Store,
+		head:           func() uint64 { return 0 },
+		serviceNames:   make([]string, 0),
+		services:       make(map[string]Service),
+	}
+	if opts.RPC.Default != nil {
+		proxy.head = opts.RPC.Default.Head
+	}
+	proxy.jobs = jobs.NewQueue(ctx, opts.Jobs.Store, proxy.runJob, opts.Jobs.Queue)
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", uri.String(), bytes.NewReader(body))
 		if err != nil {
 			return nil, err
 		}
+		for name, values := range outgoingHeader(ctx) {
+			req.Header[name] = values
+		}
 		req.Header.Set("Content-Type", "application/json")
 
 		res, err := client.Do(req)
 		if err != nil {
 			return nil, err
 		}
-		data, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return nil, err
-		}
 		defer res.Body.Close()
 
-		return data, nil
+		return ioutil.ReadAll(res.Body)
 	}
-	proxy.polling = func(r *http.Request, uri *url.URL, body []byte, names []string) ([]byte, error) {
-		logrus.Infof("start %s.pooling", names[0])
+	proxy.polling = func(ctx context.Context, uri *url.URL, body []byte, isEmpty func(data []byte) (bool, error)) ([]byte, error) {
+		logrus.Infof("start %s.pooling", uri)
 		type response struct {
 			data []byte
 			err  error
 		}
 		datach := make(chan response, 1)
-		ticker := time.NewTicker(200 * time.Millisecond)
+		// stops the polling goroutine and cancels its request when polling returns
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// notifications wake polling up as soon as rows are indexed, the
+		// ticker is a fallback then and the first attempt is done right away
+		var wake <-chan struct{}
+		interval := 200 * time.Millisecond
+		if proxy.notifier != nil {
+			ch, unsubscribe := proxy.notifier.Subscribe()
+			defer unsubscribe()
+			wake = ch
+			interval = proxy.notifyFallback
+		}
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
 		go func(ch chan response, tick *time.Ticker) {
-			for now := range tick.C {
-				log := logrus.WithField("ticker", now)
+			attempt := func(log *logrus.Entry) bool {
 				log.Debug("trying to pull data")
+				prom.PollingIteration()
 
-				data, err := proxy.forward(uri, body)
+				data, err := proxy.forward(ctx, uri, body)
 				if err != nil {
 					log.WithError(err).Debug("have error after request to postgql")
 					ch <- response{err: err}
-					return
+					return true
 				}
 
-				isEmpty := false
-				for _, name := range names {
-					empty, err := proxy.services[name].IsEmpty(data)
-					if err != nil {
-						log.WithError(err).Debug("have error response parsing")
-						ch <- response{err: err}
-						return
-					}
-					isEmpty = isEmpty || empty
+				empty, err := isEmpty(data)
+				if err != nil {
+					log.WithError(err).Debug("have error response parsing")
+					ch <- response{err: err}
+					return true
 				}
-				if !isEmpty {
+				if !empty {
 					log.WithField("data", string(data)).Debug("have some response")
 					ch <- response{data: data}
+					return true
+				}
+				return false
+			}
+
+			if wake != nil && attempt(logrus.WithField("notification", "initial")) {
+				return
+			}
+			last := time.Now()
+			for {
+				var log *logrus.Entry
+				select {
+				case now := <-tick.C:
+					log = logrus.WithField("ticker", now)
+				case <-wake:
+					// every insert notifies all pollings, the ones woken up
+					// too early wait and drop notifications meanwhile
+					if wait := proxy.notifyMin - time.Since(last); wait > 0 {
+						select {
+						case <-time.After(wait):
+						case <-ctx.Done():
+							return
+						}
+						select {
+						case <-wake:
+						default:
+						}
+					}
+					log = logrus.WithField("notification", time.Now())
+				case <-ctx.Done():
+					return
+				}
+				last = time.Now()
+				if attempt(log) {
 					return
 				}
 			}
 		}(datach, ticker)
 
+		timeout := time.NewTimer(15 * time.Second)
+		defer timeout.Stop()
 		select {
-		case <-r.Context().Done():
+		case <-ctx.Done():
 			return nil, nil
-		case <-time.After(15 * time.Second):
-			return nil, fmt.Errorf("polling timeout")
+		case <-timeout.C:
+			prom.PollingTimeout()
+			return nil, ErrPollingTimeout
 		case resp := <-datach:
 			return resp.data, resp.err
 		}
@@ -117,97 +250,660 @@ func NewHTTPReverseProxy(opts *Options) *HTTPReverseProxy {
 	return &proxy
 }
 
-// Register new service
+// Shutdown wait for gap-fill jobs until the context is done and cancel the
+// rest, unfinished jobs are resumed on the next start
+func (handler *HTTPReverseProxy) Shutdown(ctx context.Context) error {
+	return handler.jobs.Shutdown(ctx)
+}
+
+// Resume restart gap-fill jobs left pending by the previous run, it must be
+// called after all services are registered
+func (handler *HTTPReverseProxy) Resume() error {
+	return handler.jobs.Resume()
+}
+
+// Register new service, it replaces the service of the same name
 func (handler *HTTPReverseProxy) Register(srv Service) *HTTPReverseProxy {
 	handler.mu.Lock()
 	defer handler.mu.Unlock()
 
-	handler.serviceNames = append(handler.serviceNames, srv.Name())
+	if _, ok := handler.services[srv.Name()]; !ok {
+		handler.serviceNames = append(handler.serviceNames, srv.Name())
+	}
 	handler.services[srv.Name()] = srv
 	return handler
 }
 
-func (handler *HTTPReverseProxy) getPQLURI(name string) *url.URL {
-	if name == "graphTransactionByTxHash" {
-		return handler.pqlTracing
+func (handler *HTTPReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var reqBody []byte
+	var err error
+	if r.Method == http.MethodGet {
+		reqBody, err = getRequest(r.URL.Query())
+	} else {
+		reqBody, err = ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
 	}
-	return handler.pqlDefault
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse(err.Error()))
+		return
+	}
+
+	// the context carries headers of postgraphile requests, it's shared by
+	// the forwarding and polling of this request
+	header := handler.headers.outgoing(r)
+	ctx := withOutgoingHeader(r.Context(), header)
+	if id := header.Get(RequestIDHeader); id != "" {
+		w.Header().Set(RequestIDHeader, id)
+	}
+	if client, ok := auth.FromContext(ctx); ok {
+		ctx = withClientID(ctx, client.ID)
+	} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ctx = withClientID(ctx, host)
+	}
+	opts := execOptions{
+		async: strings.EqualFold(r.Header.Get(ModeHeader), ModeAsync),
+		get:   r.Method == http.MethodGet,
+	}
+	opts.lookup, opts.store = cacheControl(r)
+
+	// operations of a batch are executed concurrently, their fills of the
+	// same service and arguments share a single job
+	if batch, ok := parseBatch(reqBody); ok {
+		ops := make([]*operation, len(batch))
+		wg := new(sync.WaitGroup)
+		for i := range batch {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				ops[i] = handler.execute(ctx, batch[i], opts)
+			}(i)
+		}
+		wg.Wait()
+
+		body := []byte{'['}
+		hits, total := 0, 0
+		for i, op := range ops {
+			if i > 0 {
+				body = append(body, ',')
+			}
+			body = append(body, op.resp.Bytes()...)
+			hits += op.hits
+			total += op.total
+		}
+		body = append(body, ']')
+		if handler.cache != nil && total > 0 {
+			w.Header().Set(CacheHeader, cacheStatus(opts.lookup, hits, total))
+		}
+		writeJSON(w, http.StatusOK, body)
+		return
+	}
+
+	op := handler.execute(ctx, reqBody, opts)
+	if handler.cache != nil && op.total > 0 {
+		w.Header().Set(CacheHeader, cacheStatus(opts.lookup, op.hits, op.total))
+	}
+	// responses of final blocks never change, so GET ones are cacheable
+	// by HTTP caches and CDNs
+	if opts.get {
+		if op.immutable && handler.cacheMaxAge > 0 {
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(handler.cacheMaxAge.Seconds())))
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+	}
+	status := op.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, op.resp.Bytes())
 }
 
-func (handler *HTTPReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	reqBody, err := ioutil.ReadAll(r.Body)
+type execOptions struct {
+	async bool
+	// lookup and store in the response cache
+	lookup bool
+	store  bool
+	// get requests may only run queries
+	get bool
+}
+
+// operation is the result of a single graphql request of the batch
+type operation struct {
+	resp *response
+	// status of the response, http.StatusOK if it's zero
+	status int
+	// hits of the response cache out of total service queries
+	hits  int
+	total int
+	// immutable is true when the response consists of final blocks only
+	immutable bool
+}
+
+// failedOperation is the operation which couldn't be executed at all
+func failedOperation(status int, err error) *operation {
+	resp := newResponse()
+	resp.addError(err.Error(), requestErrorCode(err))
+	return &operation{resp: resp, status: status}
+}
+
+// parseBatch split the JSON array body into requests, ok is false when the
+// body isn't an array
+func parseBatch(body []byte) (batch [][]byte, ok bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		return nil, false
+	}
+	value, err := fastjson.ParseBytes(body)
+	if err != nil || value.Type() != fastjson.TypeArray {
+		return nil, false
+	}
+	items := value.GetArray()
+	batch = make([][]byte, len(items))
+	for i, item := range items {
+		batch[i] = item.MarshalTo(nil)
+	}
+	return batch, true
+}
+
+// forwardGroups forward the default document split by upstreams of its root
+// fields, the groups are forwarded concurrently. The document which can't be
+// split is left to the default upstream to report
+func (handler *HTTPReverseProxy) forwardGroups(ctx context.Context, resp *response, doc []byte) {
+	groups, err := qlparser.GroupSplit(doc, handler.upstream)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		groups = []qlparser.Group{{Name: qlservices.PostgraphileDefault, Doc: doc}}
 	}
-	defer r.Body.Close()
+	results := make([][]byte, len(groups))
+	errs := make([]error, len(groups))
+	wg := new(sync.WaitGroup)
+	for i := range groups {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = handler.forward(ctx, handler.upstreamURI(groups[i].Name), groups[i].Doc)
+		}(i)
+	}
+	wg.Wait()
 
-	ddoc, docs, err := qlparser.QuerySplit(reqBody, handler.serviceNames)
+	for i, group := range groups {
+		if errs[i] == nil {
+			resp.merge(results[i])
+			continue
+		}
+		msg := fmt.Sprintf("postgraphile %s: %s", group.Name, errs[i])
+		if len(group.Keys) == 0 {
+			resp.addError(msg, CodeUpstream)
+		}
+		for _, key := range group.Keys {
+			resp.data.Set(key, resp.arena.NewNull())
+			resp.addError(msg, CodeUpstream, key)
+		}
+	}
+}
 
-	var data []byte
-	if ddoc == nil {
-		o := new(fastjson.Arena).NewObject()
-		o.Set("data", new(fastjson.Arena).NewObject())
-		data = []byte(o.String())
-	} else {
-		tmp, err := handler.forward(handler.pqlDefault, ddoc)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+// execute the graphql request: forward it split by services, fill empty
+// parts and merge the results
+func (handler *HTTPReverseProxy) execute(ctx context.Context, reqBody []byte, opts execOptions) *operation {
+	client, authenticated := auth.FromContext(ctx)
+	reqBody, err := handler.persisted(reqBody)
+	if err != nil {
+		return failedOperation(http.StatusOK, err)
+	}
+	if opts.get {
+		if typ, err := qlparser.OperationType(reqBody); err != nil {
+			return failedOperation(http.StatusBadRequest, err)
+		} else if typ != "query" {
+			return failedOperation(http.StatusMethodNotAllowed, ErrMethodNotAllowed)
 		}
-		data = tmp
+	}
+	async := opts.async
+	if stripped, found, err := qlparser.StripDirective(reqBody, AsyncDirective); err == nil {
+		reqBody = stripped
+		async = async || found
 	}
 
-	parts := make(map[string][]byte)
-	for name := range docs {
-		uri := handler.getPQLURI(name)
-		tmp, err := handler.forward(uri, docs[name])
+	// introspection is answered from the schema merged of all upstreams
+	if schema.IsIntrospection(reqBody) {
+		resp := newResponse()
+		data, err := handler.introspect(ctx, reqBody)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			resp.addError(fmt.Sprintf("postgraphile: %s", err), CodeUpstream)
+		} else {
+			resp.merge(data)
+		}
+		return &operation{resp: resp}
+	}
+
+	ddoc, queries, err := qlparser.QuerySplit(reqBody, handler.serviceNames)
+	if err != nil {
+		// postgraphile reports malformed requests itself
+		logrus.WithError(err).Debug("couldn't split the request")
+		ddoc, queries = reqBody, nil
+	}
+	defer func(start time.Time) {
+		duration := time.Since(start)
+		if ddoc != nil {
+			prom.Request("other", duration)
+		}
+		for _, query := range queries {
+			prom.Request(query.Name, duration)
 		}
-		parts[name] = tmp
+	}(time.Now())
+
+	resp := newResponse()
+	if ddoc != nil {
+		handler.forwardGroups(ctx, resp, ddoc)
 	}
 
+	// parts are keyed by the response key, the split documents have no
+	// aliases so results are under the field name. Failed parts are
+	// reported right away and don't take part in the gap-fill
+	mu := new(sync.Mutex)
+	parts := make(map[string][]byte)
 	params := make(map[string][]*ast.Argument)
-	for name := range docs {
-		prms, err := qlparser.GetParams(fastjson.GetBytes(docs[name], "query"), name)
+	fillErrs := make(map[string]error)
+	// cached parts are final, they are neither checked nor filled
+	cacheKeys := make(map[string]string)
+	hits := make(map[string]bool)
+	for key, query := range queries {
+		prms, err := qlparser.RequestParams(query.Doc, query.Name)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			resp.data.Set(key, resp.arena.NewNull())
+			resp.addError(err.Error(), CodeUpstream, key)
+			delete(queries, key)
+			continue
+		}
+		params[key] = prms
+
+		if handler.cache != nil {
+			if ck, err := cacheKey(handler.upstream(query.Name), query.Name, query.Doc, outgoingHeader(ctx)); err == nil {
+				cacheKeys[key] = ck
+				if opts.lookup {
+					data, ok := handler.cache.Get(ck)
+					prom.CacheLookup(query.Name, ok)
+					if ok {
+						parts[key] = data
+						hits[key] = true
+						continue
+					}
+				}
+			}
 		}
-		params[name] = prms
+
+		uri := handler.upstreamURI(handler.upstream(query.Name))
+		tmp, err := handler.forward(ctx, uri, query.Doc)
+		if err != nil {
+			resp.data.Set(key, resp.arena.NewNull())
+			resp.addError(fmt.Sprintf("postgraphile: %s", err), CodeUpstream, key)
+			delete(queries, key)
+			continue
+		}
+		parts[key] = tmp
 	}
 
-	wg := new(sync.WaitGroup)
-	for name := range docs {
-		isEmpty, _ := handler.services[name].IsEmpty(parts[name])
-		if !isEmpty {
+	// parts are classified before fills start, fill goroutines write parts
+	// and fillErrs under mu
+	type pendingFill struct {
+		key, name string
+		doc       []byte
+		args      []*ast.Argument
+		missing   []uint64
+	}
+	pending := make([]pendingFill, 0)
+	submitted := make([]jobs.Job, 0)
+	for key, query := range queries {
+		name := query.Name
+		srv := handler.services[name]
+		if hits[key] {
+			continue
+		}
+		if fastjson.ValidateBytes(parts[key]) != nil {
+			// bad upstream response is reported on merge
+			continue
+		}
+		var missing []uint64
+		if rangeSrv, ok := srv.(RangeService); ok {
+			heights, err := rangeSrv.Missing(params[key], parts[key])
+			if err != nil || len(heights) == 0 {
+				continue
+			}
+			missing = heights
+		} else if isEmpty, _ := srv.IsEmpty(parts[key]); !isEmpty {
 			continue
 		}
+		prom.EmptyResponse(name)
+		if authenticated && !client.CanFill(name) {
+			fillErrs[key] = ErrFillForbidden
+			continue
+		}
+		if async {
+			jobs, err := handler.submit(ctx, name, params[key], missing)
+			submitted = append(submitted, jobs...)
+			if err != nil {
+				fillErrs[key] = err
+			}
+			continue
+		}
+		pending = append(pending, pendingFill{key, name, query.Doc, params[key], missing})
+	}
+
+	wg := new(sync.WaitGroup)
+	for _, p := range pending {
 		wg.Add(1)
-		go func(wg *sync.WaitGroup, doc []byte, name string, args []*ast.Argument) {
+		go func(wg *sync.WaitGroup, key string, doc []byte, name string, args []*ast.Argument, missing []uint64) {
 			defer wg.Done()
-			if err := handler.services[name].Do(args); err != nil {
-				logrus.WithError(err).Errorf("%s.Do call", name)
-				return
+			isEmpty := handler.services[name].IsEmpty
+			if missing == nil {
+				if err := handler.fill(ctx, name, qlparser.PrintArgs(args)); err != nil {
+					if premature(err) {
+						logrus.WithError(err).Debugf("%s.Do call", name)
+						return
+					}
+					if errors.Is(err, ErrKnownFailure) {
+						logrus.WithError(err).Debugf("%s.Do call", name)
+					} else {
+						logrus.WithError(err).Errorf("%s.Do call", name)
+					}
+					mu.Lock()
+					fillErrs[key] = err
+					mu.Unlock()
+					return
+				}
+			} else {
+				check, err := handler.fillRange(ctx, handler.services[name].(RangeService), args, missing)
+				if err != nil {
+					if premature(err) {
+						logrus.WithError(err).Debugf("%s.DoAt call", name)
+						return
+					}
+					if errors.Is(err, ErrKnownFailure) {
+						logrus.WithError(err).Debugf("%s.DoAt call", name)
+					} else {
+						logrus.WithError(err).Errorf("%s.DoAt call", name)
+					}
+					mu.Lock()
+					fillErrs[key] = err
+					mu.Unlock()
+					return
+				}
+				isEmpty = check
 			}
-			uri := handler.getPQLURI(name)
-			tmp, err := handler.polling(r, uri, doc, []string{name})
-			if err == nil {
-				parts[name] = tmp
+			uri := handler.upstreamURI(handler.upstream(name))
+			// polling timeouts aren't memoized, the indexer is likely lagging
+			// and the next request may find the data
+			tmp, err := handler.polling(ctx, uri, doc, isEmpty)
+			mu.Lock()
+			if err != nil {
+				fillErrs[key] = err
+			} else if tmp != nil {
+				parts[key] = tmp
 			}
-		}(wg, docs[name], name, params[name])
+			mu.Unlock()
+		}(wg, p.key, p.doc, p.name, p.args, p.missing)
 	}
 	wg.Wait()
 
-	common := fastjson.MustParseBytes(data)
-	for name := range parts {
-		part := fastjson.MustParseBytes(parts[name])
-		data := common.Get("data")
-		data.Set(name, part.Get("data", name))
-		common.Set("data", data)
+	// parts are merged in the order of the document selections, so the
+	// response keeps the order of the request
+	order, _ := qlparser.ResponseKeys(reqBody)
+	keys := make([]string, 0, len(parts))
+	for _, key := range order {
+		if _, ok := parts[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) < len(parts) {
+		keys = keys[:0]
+		for key := range parts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+	final := len(hits)
+	for _, key := range keys {
+		resp.mergePart(key, queries[key].Name, parts[key])
+		if ck := cacheKeys[key]; ck != "" && !hits[key] && fillErrs[key] == nil &&
+			handler.cacheable(handler.services[queries[key].Name], params[key], parts[key]) {
+			final++
+			if opts.store {
+				handler.cache.Set(ck, parts[key])
+			}
+		}
+		if err, ok := fillErrs[key]; ok {
+			code := CodeFillFailed
+			switch {
+			case errors.Is(err, ErrPollingTimeout):
+				code = CodeFillTimeout
+			case errors.Is(err, ErrFillForbidden):
+				code = CodeFillForbidden
+			case errors.Is(err, ErrRateLimited):
+				code = CodeRateLimited
+			case errors.Is(err, ErrOverloaded):
+				code = CodeOverloaded
+			case errors.Is(err, ErrKnownFailure):
+				code = CodeKnownFailure
+			}
+			resp.addError(fmt.Sprintf("gap-fill of %s: %s", queries[key].Name, err), code, key)
+		}
+	}
+	// the default document and failed parts are merged before the rest
+	resp.order(order)
+	if len(submitted) > 0 {
+		resp.setExtensions(jobsExtension(submitted))
+	}
+	return &operation{
+		resp:      resp,
+		hits:      len(hits),
+		total:     len(queries),
+		immutable: ddoc == nil && resp.length == 0 && len(queries) > 0 && final == len(queries),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// submit gap-fill jobs without waiting for them, it returns their current
+// state. Submits stop at the first job over the limits
+func (handler *HTTPReverseProxy) submit(ctx context.Context, name string, args []*ast.Argument, missing []uint64) ([]jobs.Job, error) {
+	keys := []string{qlparser.PrintArgs(args)}
+	if missing != nil {
+		keys = make([]string, 0, len(missing))
+		for _, n := range missing {
+			keys = append(keys, heightArgs(n))
+		}
+	}
+
+	submitted := make([]jobs.Job, 0, len(keys))
+	for _, key := range keys {
+		if err := handler.failures.check(name, key); err != nil {
+			return submitted, err
+		}
+		if err := handler.limits.admit(ctx, false); err != nil {
+			return submitted, err
+		}
+		job, err := handler.jobs.Get(handler.jobs.Submit(name, key).ID)
+		if err != nil {
+			logrus.WithError(err).Errorf("%s job submit", name)
+			continue
+		}
+		submitted = append(submitted, job)
 	}
+	return submitted, nil
+}
+
+// JobsHandler serve state of gap-fill jobs by id, the id is the last
+// element of the path
+func (handler *HTTPReverseProxy) JobsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		job, err := handler.jobs.Get(path.Base(r.URL.Path))
+		if errors.Is(err, jobs.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+	})
+}
+
+// jobsExtension build `gapFiller` response extension for submitted jobs
+func jobsExtension(submitted []jobs.Job) *fastjson.Value {
+	arena := new(fastjson.Arena)
+	list := arena.NewArray()
+	for i, job := range submitted {
+		obj := arena.NewObject()
+		obj.Set("id", arena.NewString(job.ID))
+		obj.Set("service", arena.NewString(job.Service))
+		obj.Set("args", arena.NewString(job.Args))
+		obj.Set("status", arena.NewString(string(job.Status)))
+		list.SetArrayItem(i, obj)
+	}
+	gapFiller := arena.NewObject()
+	gapFiller.Set("jobs", list)
+	extensions := arena.NewObject()
+	extensions.Set("gapFiller", gapFiller)
+	return extensions
+}
+
+// fillRange write state diffs only for the missing heights with bounded
+// parallelism. It returns the check used by polling, the range is complete
+// when every height is present except the ones which failed to fill
+func (handler *HTTPReverseProxy) fillRange(ctx context.Context, srv RangeService, args []*ast.Argument, missing []uint64) (func(data []byte) (bool, error), error) {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, handler.rangeWorkers)
+		failed = make(map[uint64]error)
+	)
+	for _, n := range missing {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(n uint64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := handler.fill(ctx, srv.Name(), heightArgs(n)); err != nil {
+				logrus.WithError(err).Debugf("%s.DoAt(%d) call", srv.Name(), n)
+				mu.Lock()
+				failed[n] = err
+				mu.Unlock()
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	if len(failed) == len(missing) {
+		return nil, failed[missing[0]]
+	}
+	return func(data []byte) (bool, error) {
+		heights, err := srv.Missing(args, data)
+		if err != nil {
+			return true, err
+		}
+		for _, n := range heights {
+			if _, ok := failed[n]; !ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}, nil
+}
+
+// fill submit the gap-fill job and wait for its first attempt, retries may
+// take minutes and go on in the background. Concurrent requests of the same
+// service with the same arguments share a single job. Recent failures are
+// returned at once instead
+func (handler *HTTPReverseProxy) fill(ctx context.Context, name string, args string) error {
+	if err := handler.failures.check(name, args); err != nil {
+		return err
+	}
+	if err := handler.limits.admit(ctx, true); err != nil {
+		return err
+	}
+	job := handler.jobs.Submit(name, args)
+	select {
+	case <-job.Attempted():
+		err := job.AttemptErr()
+		if err != nil {
+			handler.failures.record(name, args, err)
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runJob do the gap-fill, heights of range services are encoded as "@N"
+func (handler *HTTPReverseProxy) runJob(ctx context.Context, name string, args string) error {
+	srv, ok := handler.services[name]
+	if !ok {
+		return fmt.Errorf("%s: %w", name, ErrUnknownService)
+	}
+	release, err := handler.limits.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if strings.HasPrefix(args, "@") {
+		rangeSrv, ok := srv.(RangeService)
+		if !ok {
+			return fmt.Errorf("%s is not a range service: %w", name, ErrUnknownService)
+		}
+		n, err := strconv.ParseUint(args[1:], 10, 64)
+		if err != nil {
+			return err
+		}
+		err = rangeSrv.DoAt(ctx, n)
+		prom.Fill(name, err)
+		return jobError(err)
+	}
+	prms, err := qlparser.ParseArgs(args)
+	if err != nil {
+		return err
+	}
+	err = srv.Do(ctx, prms)
+	prom.Fill(name, err)
+	return jobError(err)
+}
+
+// jobError stops retries of blocks beyond the chain head or without enough
+// confirmations, the response is returned empty right away and the next
+// request submits a new job
+func jobError(err error) error {
+	if premature(err) {
+		return jobs.Permanent(err)
+	}
+	return err
+}
+
+// premature is true when the block can't be filled yet
+func premature(err error) bool {
+	return errors.Is(err, qlservices.ErrFutureBlock) || errors.Is(err, qlservices.ErrNotConfirmed)
+}
+
+type headerKey struct{}
+
+// withOutgoingHeader returns the context carrying headers of postgraphile requests
+func withOutgoingHeader(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, headerKey{}, header)
+}
+
+func outgoingHeader(ctx context.Context) http.Header {
+	header, _ := ctx.Value(headerKey{}).(http.Header)
+	return header
+}
 
-	w.Write([]byte(common.String()))
+func heightArgs(n uint64) string {
+	return "@" + strconv.FormatUint(n, 10)
 }
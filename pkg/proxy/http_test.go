@@ -1,15 +1,31 @@
 package proxy
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/graphql-go/graphql/language/ast"
 	"github.com/valyala/fastjson"
+	"github.com/vulcanize/gap-filler/pkg/auth"
+	"github.com/vulcanize/gap-filler/pkg/cache"
+	"github.com/vulcanize/gap-filler/pkg/jobs"
+	"github.com/vulcanize/gap-filler/pkg/notify"
+	"github.com/vulcanize/gap-filler/pkg/qlparser"
 	"github.com/vulcanize/gap-filler/pkg/qlservices"
 )
 
@@ -22,14 +38,14 @@ func NewEthHeaderCidByBlockNumberMockService() *EthHeaderCidByBlockNumberMockSer
 	return &EthHeaderCidByBlockNumberMockService{new(qlservices.EthHeaderCidByBlockNumberService), false}
 }
 
-func (srv *EthHeaderCidByBlockNumberMockService) Do(args []*ast.Argument) error {
+func (srv *EthHeaderCidByBlockNumberMockService) Do(ctx context.Context, args []*ast.Argument) error {
 	srv.DoCalled = true
 	return nil
 }
 
 func TestEthHeaderCidByBlockNumberEmptyBody(t *testing.T) {
 	proxy := NewHTTPReverseProxy(&Options{})
-	proxy.forward = func(uri *url.URL, body []byte) ([]byte, error) {
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
 		return []byte(`{"data":{}}`), nil
 	}
 
@@ -68,7 +84,7 @@ func TestEthHeaderCidByBlockNumberSimple(t *testing.T) {
 		}
 	`
 	proxy := NewHTTPReverseProxy(&Options{})
-	proxy.forward = func(uri *url.URL, body []byte) ([]byte, error) {
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
 		return []byte(json), nil
 	}
 
@@ -110,7 +126,7 @@ func TestEthHeaderCidByBlockNumberDataPulling(t *testing.T) {
 	proxy := NewHTTPReverseProxy(&Options{})
 	servi := NewEthHeaderCidByBlockNumberMockService()
 	proxy.Register(servi)
-	proxy.forward = func(uri *url.URL, body []byte) ([]byte, error) {
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
 		return []byte(`
 			{
 				"data": {
@@ -122,7 +138,7 @@ func TestEthHeaderCidByBlockNumberDataPulling(t *testing.T) {
 		`), nil
 	}
 
-	proxy.polling = func(r *http.Request, uri *url.URL, body []byte, names []string) ([]byte, error) {
+	proxy.polling = func(ctx context.Context, uri *url.URL, body []byte, isEmpty func(data []byte) (bool, error)) ([]byte, error) {
 		return []byte(json), nil
 	}
 
@@ -145,3 +161,1061 @@ func TestEthHeaderCidByBlockNumberDataPulling(t *testing.T) {
 		t.Errorf("Want: %s, Got: '%s'", json, string(body))
 	}
 }
+
+type AllEthHeaderCidsMockService struct {
+	*qlservices.AllEthHeaderCidsService
+	mu      sync.Mutex
+	Heights []uint64
+}
+
+func (srv *AllEthHeaderCidsMockService) DoAt(ctx context.Context, n uint64) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.Heights = append(srv.Heights, n)
+	return nil
+}
+
+func TestAllEthHeaderCidsRangePulling(t *testing.T) {
+	json := `{"data":{"allEthHeaderCids":{"nodes":[{"blockNumber":"10"},{"blockNumber":"11"},{"blockNumber":"12"},{"blockNumber":"13"}]}}}`
+
+	proxy := NewHTTPReverseProxy(&Options{RangeWorkers: 2})
+	servi := &AllEthHeaderCidsMockService{AllEthHeaderCidsService: qlservices.NewAllEthHeaderCidsService(nil)}
+	proxy.Register(servi)
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		return []byte(`{"data":{"allEthHeaderCids":{"nodes":[{"blockNumber":"10"},{"blockNumber":"12"}]}}}`), nil
+	}
+	proxy.polling = func(ctx context.Context, uri *url.URL, body []byte, isEmpty func(data []byte) (bool, error)) ([]byte, error) {
+		if empty, err := isEmpty([]byte(json)); empty || err != nil {
+			t.Errorf("Want: complete range, Got: %v, %v", empty, err)
+		}
+		return []byte(json), nil
+	}
+
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(`
+		{"query":"query MyQuery {\n  allEthHeaderCids(filter: {blockNumber: {greaterThanOrEqualTo: \"10\", lessThanOrEqualTo: \"13\"}}) {\n    nodes {\n      blockNumber\n    }\n  }\n}\n","variables":null,"operationName":"MyQuery"}
+	`))
+	proxy.ServeHTTP(rr, r)
+
+	body, err := ioutil.ReadAll(rr.Body)
+	if err != nil {
+		t.Error(err)
+	}
+
+	sort.Slice(servi.Heights, func(i, j int) bool { return servi.Heights[i] < servi.Heights[j] })
+	if !reflect.DeepEqual(servi.Heights, []uint64{11, 13}) {
+		t.Errorf("Want: [11 13], Got: %v", servi.Heights)
+	}
+
+	if strings.Compare(fastjson.MustParse(json).String(), string(body)) != 0 {
+		t.Errorf("Want: %s, Got: '%s'", json, string(body))
+	}
+}
+
+func TestEthHeaderCidByBlockNumberAsync(t *testing.T) {
+	proxy := NewHTTPReverseProxy(&Options{})
+	servi := NewEthHeaderCidByBlockNumberMockService()
+	proxy.Register(servi)
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]}}}`), nil
+	}
+	proxy.polling = func(ctx context.Context, uri *url.URL, body []byte, isEmpty func(data []byte) (bool, error)) ([]byte, error) {
+		t.Error("polling must not be called in async mode")
+		return nil, nil
+	}
+
+	requests := []*http.Request{}
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(`
+		{"query":"query MyQuery {\n  ethHeaderCidByBlockNumber(n: \"123\") {\n    edges {\n      cursor\n    }\n  }\n}\n","variables":null,"operationName":"MyQuery"}
+	`))
+	r.Header.Set(ModeHeader, ModeAsync)
+	requests = append(requests, r)
+	r, _ = http.NewRequest("POST", "/", strings.NewReader(`
+		{"query":"query MyQuery @async {\n  ethHeaderCidByBlockNumber(n: \"123\") {\n    edges {\n      cursor\n    }\n  }\n}\n","variables":null,"operationName":"MyQuery"}
+	`))
+	requests = append(requests, r)
+
+	for i, r := range requests {
+		rr := httptest.NewRecorder()
+		proxy.ServeHTTP(rr, r)
+
+		body := fastjson.MustParseBytes(rr.Body.Bytes())
+		if edges := body.Get("data", "ethHeaderCidByBlockNumber", "edges"); edges == nil || edges.String() != "[]" {
+			t.Errorf("[%d] Want: empty edges, Got: %s", i, body)
+		}
+		id := string(body.GetStringBytes("extensions", "gapFiller", "jobs", "0", "id"))
+		if id == "" {
+			t.Fatalf("[%d] Want: job id, Got: %s", i, body)
+		}
+
+		rr = httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/gapfill/jobs/"+id, nil)
+		proxy.JobsHandler().ServeHTTP(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Errorf("[%d] Want: 200, Got: %d", i, rr.Code)
+		}
+		if got := fastjson.GetString(rr.Body.Bytes(), "id"); got != id {
+			t.Errorf("[%d] Want: %s, Got: %s", i, id, got)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/gapfill/jobs/unknown", nil)
+	proxy.JobsHandler().ServeHTTP(rr, r)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Want: 404, Got: %d", rr.Code)
+	}
+}
+
+func TestPollingNotification(t *testing.T) {
+	notifier := notify.NewBroadcaster()
+	proxy := NewHTTPReverseProxy(&Options{Notify: NotifyOptions{Notifier: notifier, Fallback: time.Hour}})
+	var indexed int32
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		if atomic.LoadInt32(&indexed) == 0 {
+			return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]}}}`), nil
+		}
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"cursor":"1"}]}}}`), nil
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&indexed, 1)
+		notifier.Notify()
+	}()
+
+	start := time.Now()
+	r, _ := http.NewRequest("POST", "/", nil)
+	srv := NewEthHeaderCidByBlockNumberMockService()
+	data, err := proxy.polling(r.Context(), &url.URL{}, nil, srv.IsEmpty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty, _ := srv.IsEmpty(data); empty {
+		t.Errorf("Want: indexed data, Got: %s", data)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Want: polling is woken up by notification, Got: %s", elapsed)
+	}
+}
+
+func TestPollingNotificationBurst(t *testing.T) {
+	notifier := notify.NewBroadcaster()
+	proxy := NewHTTPReverseProxy(&Options{Notify: NotifyOptions{Notifier: notifier, Fallback: time.Hour, MinInterval: 100 * time.Millisecond}})
+	var calls int32
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]}}}`), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 350*time.Millisecond)
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			notifier.Notify()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	srv := NewEthHeaderCidByBlockNumberMockService()
+	if _, err := proxy.polling(ctx, &url.URL{}, nil, srv.IsEmpty); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n < 2 || n > 5 {
+		t.Errorf("Want: one request per 100ms, Got: %d requests in 350ms", n)
+	}
+}
+
+func TestEthHeaderCidByBlockNumberAliases(t *testing.T) {
+	proxy := NewHTTPReverseProxy(&Options{})
+	proxy.Register(NewEthHeaderCidByBlockNumberMockService())
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		args, err := qlparser.RequestParams(body, "ethHeaderCidByBlockNumber")
+		if err != nil {
+			return nil, err
+		}
+		cursor := args[0].Value.GetValue().(string)
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"cursor":"` + cursor + `"}]}}}`), nil
+	}
+
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(`
+		{"query":"query MyQuery { h1: ethHeaderCidByBlockNumber(n: \"1\") { edges { cursor } } h2: ethHeaderCidByBlockNumber(n: \"2\") { edges { cursor } } }","variables":null,"operationName":"MyQuery"}
+	`))
+	proxy.ServeHTTP(rr, r)
+
+	body := fastjson.MustParseBytes(rr.Body.Bytes())
+	for key, want := range map[string]string{"h1": "1", "h2": "2"} {
+		if got := string(body.GetStringBytes("data", key, "edges", "0", "cursor")); got != want {
+			t.Errorf("[%s] Want: %s, Got: %s", key, want, body)
+		}
+	}
+}
+
+type FailingMockService struct {
+	*qlservices.EthHeaderCidByBlockNumberService
+}
+
+func (srv *FailingMockService) Do(ctx context.Context, args []*ast.Argument) error {
+	return errors.New("statediff is unavailable")
+}
+
+func TestPartialResults(t *testing.T) {
+	proxy := NewHTTPReverseProxy(&Options{})
+	proxy.Register(&FailingMockService{new(qlservices.EthHeaderCidByBlockNumberService)})
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		switch {
+		case strings.Contains(string(body), "nodeId"):
+			return []byte(`{"data":{"node":null},"errors":[{"message":"not found","path":["node"]}]}`), nil
+		case strings.Contains(string(body), `\"2\"`):
+			return []byte(`<html>Bad Gateway</html>`), nil
+		}
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]}}}`), nil
+	}
+
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(`
+		{"query":"query MyQuery { h1: ethHeaderCidByBlockNumber(n: \"1\") { edges { cursor } } h2: ethHeaderCidByBlockNumber(n: \"2\") { edges { cursor } } node(nodeId: \"x\") { id } }","variables":null,"operationName":"MyQuery"}
+	`))
+	proxy.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Want: 200, Got: %d", rr.Code)
+	}
+	body, err := fastjson.ParseBytes(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edges := body.Get("data", "h1", "edges"); edges == nil || edges.String() != "[]" {
+		t.Errorf("Want: empty edges of h1, Got: %s", body)
+	}
+	if node := body.Get("data", "node"); node == nil || node.Type() != fastjson.TypeNull {
+		t.Errorf("Want: null node, Got: %s", body)
+	}
+
+	codes := make(map[string]string)
+	for _, item := range body.GetArray("errors") {
+		path := item.GetArray("path")
+		if len(path) == 0 {
+			t.Errorf("Want: error path, Got: %s", item)
+			continue
+		}
+		codes[string(path[0].GetStringBytes())] = string(item.GetStringBytes("extensions", "code"))
+	}
+	want := map[string]string{"node": "", "h1": CodeFillFailed, "h2": CodeUpstream}
+	if !reflect.DeepEqual(codes, want) {
+		t.Errorf("Want: %v, Got: %v", want, codes)
+	}
+}
+
+func TestPollingCancel(t *testing.T) {
+	proxy := NewHTTPReverseProxy(&Options{})
+	canceled := make(chan struct{})
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+	srv := NewEthHeaderCidByBlockNumberMockService()
+	data, err := proxy.polling(ctx, &url.URL{}, nil, srv.IsEmpty)
+	if data != nil || err != nil {
+		t.Errorf("Want: nil, nil, Got: %s, %v", data, err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("Want: postgraphile request is canceled, Got: still running")
+	}
+}
+
+func TestFillPermissions(t *testing.T) {
+	proxy := NewHTTPReverseProxy(&Options{ForwardAuthorization: true})
+	srv := NewEthHeaderCidByBlockNumberMockService()
+	proxy.Register(srv)
+	var authorization atomic.Value
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		authorization.Store(outgoingHeader(ctx).Get("Authorization"))
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]}}}`), nil
+	}
+	proxy.polling = func(ctx context.Context, uri *url.URL, body []byte, isEmpty func(data []byte) (bool, error)) ([]byte, error) {
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"cursor":"x"}]}}}`), nil
+	}
+
+	for _, tc := range []struct {
+		client *auth.Client
+		code   string
+	}{
+		{&auth.Client{ID: "reader"}, CodeFillForbidden},
+		{&auth.Client{ID: "other", Fill: true, Services: []string{"allEthHeaderCids"}}, CodeFillForbidden},
+		{&auth.Client{ID: "filler", Fill: true}, ""},
+	} {
+		srv.DoCalled = false
+		rr := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/", strings.NewReader(`
+			{"query":"query MyQuery { ethHeaderCidByBlockNumber(n: \"1\") { edges { cursor } } }","variables":null,"operationName":"MyQuery"}
+		`))
+		r.Header.Set("Authorization", "Bearer "+tc.client.ID)
+		proxy.ServeHTTP(rr, r.WithContext(auth.WithClient(r.Context(), tc.client)))
+
+		body, err := fastjson.ParseBytes(rr.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		code := string(body.GetStringBytes("errors", "0", "extensions", "code"))
+		if code != tc.code {
+			t.Errorf("[%s] Want: %q, Got: %s", tc.client.ID, tc.code, body)
+		}
+		if srv.DoCalled != (tc.code == "") {
+			t.Errorf("[%s] Want: Do called %v, Got: %v", tc.client.ID, tc.code == "", srv.DoCalled)
+		}
+		if got := authorization.Load(); got != "Bearer "+tc.client.ID {
+			t.Errorf("[%s] Want: forwarded Authorization, Got: %v", tc.client.ID, got)
+		}
+	}
+}
+
+type BlockingMockService struct {
+	*qlservices.EthHeaderCidByBlockNumberService
+	started chan struct{}
+	release chan struct{}
+}
+
+func (srv *BlockingMockService) Do(ctx context.Context, args []*ast.Argument) error {
+	srv.started <- struct{}{}
+	<-srv.release
+	return nil
+}
+
+func limitedRequest(proxy *HTTPReverseProxy, n, remoteAddr string) string {
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(`
+		{"query":"query MyQuery { ethHeaderCidByBlockNumber(n: \"`+n+`\") { edges { cursor } } }","variables":null,"operationName":"MyQuery"}
+	`))
+	r.RemoteAddr = remoteAddr
+	proxy.ServeHTTP(rr, r)
+	body, err := fastjson.ParseBytes(rr.Body.Bytes())
+	if err != nil {
+		return err.Error()
+	}
+	return string(body.GetStringBytes("errors", "0", "extensions", "code"))
+}
+
+func TestFillLimits(t *testing.T) {
+	empty := func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]}}}`), nil
+	}
+	filled := func(ctx context.Context, uri *url.URL, body []byte, isEmpty func(data []byte) (bool, error)) ([]byte, error) {
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"cursor":"x"}]}}}`), nil
+	}
+
+	t.Run("client rate", func(t *testing.T) {
+		proxy := NewHTTPReverseProxy(&Options{Limits: LimitsOptions{ClientRate: 0.001, ClientBurst: 1, Policy: LimitReject}})
+		proxy.Register(NewEthHeaderCidByBlockNumberMockService())
+		proxy.forward, proxy.polling = empty, filled
+
+		for i, tc := range []struct {
+			n, addr, code string
+		}{
+			{"1", "10.0.0.1:1000", ""},
+			{"2", "10.0.0.1:1001", CodeRateLimited},
+			{"3", "10.0.0.2:1000", ""},
+		} {
+			if code := limitedRequest(proxy, tc.n, tc.addr); code != tc.code {
+				t.Errorf("[%d] Want: %q, Got: %q", i, tc.code, code)
+			}
+		}
+	})
+
+	t.Run("max fills", func(t *testing.T) {
+		proxy := NewHTTPReverseProxy(&Options{Limits: LimitsOptions{MaxFills: 1, Policy: LimitReject}})
+		srv := &BlockingMockService{new(qlservices.EthHeaderCidByBlockNumberService), make(chan struct{}), make(chan struct{})}
+		proxy.Register(srv)
+		proxy.forward, proxy.polling = empty, filled
+
+		done := make(chan string)
+		go func() {
+			done <- limitedRequest(proxy, "1", "10.0.0.1:1000")
+		}()
+		<-srv.started
+		if code := limitedRequest(proxy, "2", "10.0.0.2:1000"); code != CodeOverloaded {
+			t.Errorf("Want: %q, Got: %q", CodeOverloaded, code)
+		}
+		close(srv.release)
+		if code := <-done; code != "" {
+			t.Errorf("Want: no error of the first request, Got: %q", code)
+		}
+	})
+}
+
+func TestResponseCache(t *testing.T) {
+	lru, err := cache.NewLRU(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := NewHTTPReverseProxy(&Options{Cache: CacheOptions{Cache: lru, Finality: 10}})
+	proxy.Register(NewEthHeaderCidByBlockNumberMockService())
+	proxy.head = func() uint64 { return 200 }
+	var forwarded int32
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		atomic.AddInt32(&forwarded, 1)
+		n := "190"
+		if strings.Contains(string(body), "191") {
+			n = "191"
+		}
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"node":{"blockNumber":"` + n + `"}}]}}}`), nil
+	}
+
+	request := func(n, cacheControl string) (string, string) {
+		rr := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/", strings.NewReader(`
+			{"query":"query MyQuery($n: BigInt!) { ethHeaderCidByBlockNumber(n: $n) { edges { node { blockNumber } } } }","variables":{"n":"`+n+`"},"operationName":"MyQuery"}
+		`))
+		if cacheControl != "" {
+			r.Header.Set("Cache-Control", cacheControl)
+		}
+		proxy.ServeHTTP(rr, r)
+		return rr.Header().Get(CacheHeader), rr.Body.String()
+	}
+
+	for i, tc := range []struct {
+		n, cacheControl string
+		status          string
+		forwarded       int32
+	}{
+		{"190", "", "miss", 1},
+		{"190", "", "hit", 1},
+		{"190", "no-cache", "bypass", 2},
+		{"191", "", "miss", 3},
+		// 191 is not final yet
+		{"191", "", "miss", 4},
+	} {
+		status, body := request(tc.n, tc.cacheControl)
+		if status != tc.status || atomic.LoadInt32(&forwarded) != tc.forwarded {
+			t.Errorf("[%d] Want: %s after %d requests, Got: %s after %d", i, tc.status, tc.forwarded, status, forwarded)
+		}
+		if !strings.Contains(body, `"blockNumber":"`+tc.n+`"`) {
+			t.Errorf("[%d] Want: block %s, Got: %s", i, tc.n, body)
+		}
+	}
+	if lru.Len() != 1 {
+		t.Errorf("Want: 1 cached response, Got: %d", lru.Len())
+	}
+}
+
+type rpcError string
+
+func (e rpcError) Error() string  { return string(e) }
+func (e rpcError) ErrorCode() int { return -32000 }
+
+type RPCErrorMockService struct {
+	*qlservices.EthHeaderCidByBlockNumberService
+	calls int32
+}
+
+func (srv *RPCErrorMockService) Do(ctx context.Context, args []*ast.Argument) error {
+	atomic.AddInt32(&srv.calls, 1)
+	return rpcError("header not found")
+}
+
+func TestFillFirstAttempt(t *testing.T) {
+	proxy := NewHTTPReverseProxy(&Options{Jobs: JobsOptions{Queue: jobs.Options{Retries: 5, MinBackoff: time.Hour}}})
+	defer proxy.jobs.Close()
+	srv := &RPCErrorMockService{EthHeaderCidByBlockNumberService: new(qlservices.EthHeaderCidByBlockNumberService)}
+	proxy.Register(srv)
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]}}}`), nil
+	}
+
+	start := time.Now()
+	if code := limitedRequest(proxy, "1", "10.0.0.1:1000"); code != CodeFillFailed {
+		t.Errorf("Want: %s, Got: %s", CodeFillFailed, code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Want: response after the first attempt, Got: %s", elapsed)
+	}
+	if job, err := proxy.jobs.Get(jobs.ID("ethHeaderCidByBlockNumber", `n: "1"`)); err != nil || job.Status != jobs.StatusPending {
+		t.Errorf("Want: job is retried in the background, Got: %s %v", job.Status, err)
+	}
+}
+
+func TestKnownFailures(t *testing.T) {
+	proxy := NewHTTPReverseProxy(&Options{Failures: FailuresOptions{TTL: map[FailureCategory]time.Duration{FailureNotFound: time.Minute}}})
+	srv := &RPCErrorMockService{EthHeaderCidByBlockNumberService: new(qlservices.EthHeaderCidByBlockNumberService)}
+	proxy.Register(srv)
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]}}}`), nil
+	}
+
+	for i, tc := range []struct {
+		code  string
+		calls int32
+	}{
+		{CodeFillFailed, 1},
+		{CodeKnownFailure, 1},
+	} {
+		if code := limitedRequest(proxy, "1", "10.0.0.1:1000"); code != tc.code || atomic.LoadInt32(&srv.calls) != tc.calls {
+			t.Errorf("[%d] Want: %s after %d calls, Got: %s after %d", i, tc.code, tc.calls, code, srv.calls)
+		}
+	}
+
+	handler := proxy.FailuresHandler()
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest("DELETE", "/gapfill/failures?service=ethHeaderCidByBlockNumber", nil)
+	handler.ServeHTTP(rr, r.WithContext(auth.WithClient(r.Context(), &auth.Client{ID: "reader"})))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Want: 403 for non-admin, Got: %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/gapfill/failures", nil))
+	list, err := fastjson.ParseBytes(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := list.GetArray(); len(items) != 1 || string(items[0].GetStringBytes("category")) != string(FailureNotFound) {
+		t.Errorf("Want: single block_not_found failure, Got: %s", list)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("DELETE", "/gapfill/failures?service=ethHeaderCidByBlockNumber", nil))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Want: 403 without authentication, Got: %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	r = httptest.NewRequest("DELETE", "/gapfill/failures?service=ethHeaderCidByBlockNumber", nil)
+	handler.ServeHTTP(rr, r.WithContext(auth.WithClient(r.Context(), &auth.Client{ID: "admin", Admin: true})))
+	if body := strings.TrimSpace(rr.Body.String()); body != `{"cleared":1}` {
+		t.Errorf("Want: 1 cleared, Got: %s", body)
+	}
+	if code := limitedRequest(proxy, "1", "10.0.0.1:1000"); code != CodeFillFailed || atomic.LoadInt32(&srv.calls) != 2 {
+		t.Errorf("Want: fill is retried after clear, Got: %s after %d calls", code, srv.calls)
+	}
+
+	proxy.failures.allowClear = true
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("DELETE", "/gapfill/failures", nil))
+	if body := strings.TrimSpace(rr.Body.String()); body != `{"cleared":1}` {
+		t.Errorf("Want: 1 cleared without authentication when allowed, Got: %s", body)
+	}
+	rr = httptest.NewRecorder()
+	r = httptest.NewRequest("DELETE", "/gapfill/failures", nil)
+	handler.ServeHTTP(rr, r.WithContext(auth.WithClient(r.Context(), &auth.Client{ID: "reader"})))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Want: 403 for non-admin when allowed, Got: %d", rr.Code)
+	}
+}
+
+func TestPollingTimeoutNotMemoized(t *testing.T) {
+	proxy := NewHTTPReverseProxy(&Options{Failures: FailuresOptions{TTL: map[FailureCategory]time.Duration{FailureRPC: time.Minute}}})
+	srv := NewEthHeaderCidByBlockNumberMockService()
+	proxy.Register(srv)
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]}}}`), nil
+	}
+	proxy.polling = func(ctx context.Context, uri *url.URL, body []byte, isEmpty func(data []byte) (bool, error)) ([]byte, error) {
+		return nil, ErrPollingTimeout
+	}
+
+	for i := 0; i < 2; i++ {
+		srv.DoCalled = false
+		if code := limitedRequest(proxy, "1", "10.0.0.1:1000"); code != CodeFillTimeout || !srv.DoCalled {
+			t.Errorf("[%d] Want: %s after fill, Got: %s, fill called: %v", i, CodeFillTimeout, code, srv.DoCalled)
+		}
+	}
+	if list := proxy.failures.list(); len(list) != 0 {
+		t.Errorf("Want: no memoized failures, Got: %v", list)
+	}
+}
+
+type CountingMockService struct {
+	*qlservices.EthHeaderCidByBlockNumberService
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (srv *CountingMockService) Do(ctx context.Context, args []*ast.Argument) error {
+	time.Sleep(100 * time.Millisecond)
+	srv.mu.Lock()
+	srv.calls[qlparser.PrintArgs(args)]++
+	srv.mu.Unlock()
+	return nil
+}
+
+func TestBatch(t *testing.T) {
+	proxy := NewHTTPReverseProxy(&Options{})
+	srv := &CountingMockService{new(qlservices.EthHeaderCidByBlockNumberService), sync.Mutex{}, make(map[string]int)}
+	proxy.Register(srv)
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		if strings.Contains(string(body), "nodeId") {
+			return []byte(`{"data":{"node":{"id":"x"}}}`), nil
+		}
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]}}}`), nil
+	}
+	proxy.polling = func(ctx context.Context, uri *url.URL, body []byte, isEmpty func(data []byte) (bool, error)) ([]byte, error) {
+		n := "1"
+		if strings.Contains(string(body), `\"2\"`) || strings.Contains(string(body), `"2"`) {
+			n = "2"
+		}
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"cursor":"` + n + `"}]}}}`), nil
+	}
+
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(`[
+		{"query":"query A { ethHeaderCidByBlockNumber(n: \"1\") { edges { cursor } } }","operationName":"A"},
+		{"query":"query B($n: BigInt!) { h: ethHeaderCidByBlockNumber(n: $n) { edges { cursor } } }","variables":{"n":"2"},"operationName":"B"},
+		{"query":"query C { node(nodeId: \"x\") { id } }","operationName":"C"},
+		{"query":"query D { ethHeaderCidByBlockNumber(n: \"1\") { edges { cursor } } }","operationName":"D"}
+	]`))
+	proxy.ServeHTTP(rr, r)
+
+	body, err := fastjson.ParseBytes(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := body.GetArray()
+	if len(results) != 4 {
+		t.Fatalf("Want: 4 results, Got: %s", body)
+	}
+	for i, want := range []string{
+		`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"cursor":"1"}]}}}`,
+		`{"data":{"h":{"edges":[{"cursor":"2"}]}}}`,
+		`{"data":{"node":{"id":"x"}}}`,
+		`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"cursor":"1"}]}}}`,
+	} {
+		if got := results[i].String(); got != want {
+			t.Errorf("[%d] Want: %s, Got: %s", i, want, got)
+		}
+	}
+	want := map[string]int{`n: "1"`: 1, `n: "2"`: 1}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !reflect.DeepEqual(srv.calls, want) {
+		t.Errorf("Want: fills %v, Got: %v", want, srv.calls)
+	}
+}
+
+func TestGetRequest(t *testing.T) {
+	lru, err := cache.NewLRU(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := NewHTTPReverseProxy(&Options{Cache: CacheOptions{Cache: lru, Finality: 10, MaxAge: time.Hour}})
+	proxy.Register(NewEthHeaderCidByBlockNumberMockService())
+	proxy.head = func() uint64 { return 200 }
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		n := "190"
+		if strings.Contains(string(body), "195") {
+			n = "195"
+		}
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"node":{"blockNumber":"` + n + `"}}]}}}`), nil
+	}
+
+	for i, tc := range []struct {
+		query, variables string
+		status           int
+		cacheControl     string
+		body             string
+	}{
+		{`query MyQuery($n: BigInt!) { ethHeaderCidByBlockNumber(n: $n) { edges { node { blockNumber } } } }`, `{"n":"190"}`, http.StatusOK, "public, max-age=3600", `"blockNumber":"190"`},
+		// 195 is not final yet
+		{`{ ethHeaderCidByBlockNumber(n: "195") { edges { node { blockNumber } } } }`, "", http.StatusOK, "no-cache", `"blockNumber":"195"`},
+		{`mutation { ethHeaderCidByBlockNumber(n: "190") { edges { node { blockNumber } } } }`, "", http.StatusMethodNotAllowed, "", ErrMethodNotAllowed.Error()},
+		{`{ ethHeaderCidByBlockNumber(n: "190") }`, `{"n":`, http.StatusBadRequest, "", "bad variables"},
+	} {
+		params := url.Values{"query": {tc.query}}
+		if tc.variables != "" {
+			params.Set("variables", tc.variables)
+		}
+		rr := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/?"+params.Encode(), nil)
+		proxy.ServeHTTP(rr, r)
+		if rr.Code != tc.status || tc.cacheControl != "" && rr.Header().Get("Cache-Control") != tc.cacheControl {
+			t.Errorf("[%d] Want: %d %q, Got: %d %q", i, tc.status, tc.cacheControl, rr.Code, rr.Header().Get("Cache-Control"))
+		}
+		if !strings.Contains(rr.Body.String(), tc.body) {
+			t.Errorf("[%d] Want: %s, Got: %s", i, tc.body, rr.Body.String())
+		}
+	}
+}
+
+func TestPersistedQueries(t *testing.T) {
+	store, err := cache.NewLRU(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := NewHTTPReverseProxy(&Options{Persisted: PersistedOptions{Store: store}})
+	proxy.Register(NewEthHeaderCidByBlockNumberMockService())
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		if strings.Contains(string(body), "persistedQuery") {
+			t.Errorf("Extension is forwarded: %s", body)
+		}
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"node":{"cid":"cid"}}]}}}`), nil
+	}
+
+	query := `{ ethHeaderCidByBlockNumber(n: "1") { edges { node { cid } } } }`
+	sum := sha256.Sum256([]byte(query))
+	hash := hex.EncodeToString(sum[:])
+	extensions := `{"persistedQuery":{"version":1,"sha256Hash":"` + hash + `"}}`
+	queryJSON, _ := json.Marshal(query)
+
+	for i, tc := range []struct {
+		body string
+		want string
+	}{
+		{`{"extensions":` + extensions + `}`, CodePersistedQueryNotFound},
+		{`{"query":` + string(queryJSON) + `,"extensions":{"persistedQuery":{"version":1,"sha256Hash":"00"}}}`, CodeBadRequest},
+		{`{"query":` + string(queryJSON) + `,"extensions":` + extensions + `}`, `"cid":"cid"`},
+		{`{"extensions":` + extensions + `}`, `"cid":"cid"`},
+	} {
+		rr := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/", strings.NewReader(tc.body))
+		proxy.ServeHTTP(rr, r)
+		if !strings.Contains(rr.Body.String(), tc.want) {
+			t.Errorf("[%d] Want: %s, Got: %s", i, tc.want, rr.Body.String())
+		}
+	}
+
+	// persisted queries are disabled without the store
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(`{"extensions":`+extensions+`}`))
+	NewHTTPReverseProxy(&Options{}).ServeHTTP(rr, r)
+	if !strings.Contains(rr.Body.String(), CodePersistedQueryNotSupported) {
+		t.Errorf("Want: %s, Got: %s", CodePersistedQueryNotSupported, rr.Body.String())
+	}
+}
+
+func TestForwardHeaders(t *testing.T) {
+	var mu sync.Mutex
+	var headers []http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header.Clone())
+		calls := len(headers)
+		mu.Unlock()
+		// the second polling iteration finds the data
+		if calls < 3 {
+			w.Write([]byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]}}}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"node":{"cid":"cid"}}]}}}`))
+	}))
+	defer upstream.Close()
+	uri, _ := url.Parse(upstream.URL)
+
+	proxy := NewHTTPReverseProxy(&Options{
+		Postgraphile: PostgraphileOptions{Default: uri},
+		Headers: HeadersOptions{
+			Allow:  []string{"Cookie", "X-Request-Id", "X-Forwarded-For", "X-Secret"},
+			Deny:   []string{"X-Secret"},
+			Static: map[string]string{"X-Source": "gap-filler"},
+		},
+	})
+	proxy.Register(NewEthHeaderCidByBlockNumberMockService())
+
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(`{"query":"{ ethHeaderCidByBlockNumber(n: \"1\") { edges { node { cid } } } }"}`))
+	r.RemoteAddr = "10.0.0.2:1234"
+	r.Header.Set("Cookie", "session=1")
+	r.Header.Set("X-Forwarded-For", "10.0.0.1")
+	r.Header.Set("X-Secret", "secret")
+	r.Header.Set("Authorization", "Bearer token")
+	r.Header.Set("X-Source", "client")
+	proxy.ServeHTTP(rr, r)
+	if !strings.Contains(rr.Body.String(), `"cid":"cid"`) {
+		t.Fatalf("Want: data, Got: %s", rr.Body.String())
+	}
+	id := rr.Header().Get(RequestIDHeader)
+	if id == "" {
+		t.Error("Want: generated request id in the response")
+	}
+
+	if len(headers) != 3 {
+		t.Fatalf("Want: forward and 2 polling requests, Got: %d", len(headers))
+	}
+	for i, header := range headers {
+		for name, want := range map[string]string{
+			"Cookie":          "session=1",
+			"X-Forwarded-For": "10.0.0.1, 10.0.0.2",
+			RequestIDHeader:   id,
+			"X-Source":        "gap-filler",
+			"Content-Type":    "application/json",
+			"X-Secret":        "",
+			"Authorization":   "",
+		} {
+			if got := header.Get(name); got != want {
+				t.Errorf("[%d] Want: %s %q, Got: %q", i, name, want, got)
+			}
+		}
+	}
+}
+
+func TestForwardedForCopy(t *testing.T) {
+	policy := newHeaderPolicy(HeadersOptions{Allow: []string{"X-Forwarded-For"}}, false)
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	forwarded := make([]string, 1, 2)
+	forwarded[0] = "10.0.0.1"
+	r.Header["X-Forwarded-For"] = forwarded
+
+	if got := policy.outgoing(r).Get("X-Forwarded-For"); got != "10.0.0.1, 10.0.0.2" {
+		t.Errorf("Want: 10.0.0.1, 10.0.0.2, Got: %s", got)
+	}
+	if spare := forwarded[:2][1]; spare != "" {
+		t.Errorf("Want: incoming header is untouched, Got: %s", spare)
+	}
+}
+
+func TestUpstreamRoutes(t *testing.T) {
+	upstream := func(name string) *url.URL {
+		return &url.URL{Scheme: "http", Host: name, Path: "/graphql"}
+	}
+	opts := PostgraphileOptions{
+		Default:    upstream("default"),
+		TracingAPI: upstream("tracing"),
+		Upstreams:  map[string]*url.URL{"archive": upstream("archive"), "down": upstream("down")},
+		Routes: []Route{
+			{Field: "ethHeaderCidByBlockNumber", Upstream: "archive"},
+			{Field: "all*", Upstream: "archive"},
+			{Field: "broken", Upstream: "down"},
+		},
+	}
+	proxy := NewHTTPReverseProxy(&Options{Postgraphile: opts})
+	proxy.Register(NewEthHeaderCidByBlockNumberMockService())
+	proxy.Register(qlservices.NewGetGraphCallByTxHashService(nil))
+	if err := proxy.router.validate(proxy.services); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	forwarded := make(map[string][]string)
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		forwarded[uri.Host] = append(forwarded[uri.Host], string(body))
+		switch {
+		case uri.Host == "down":
+			return nil, errors.New("connection refused")
+		case strings.Contains(string(body), "ethHeaderCidByBlockNumber"):
+			return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"node":{"cid":"cid"}}]}}}`), nil
+		case strings.Contains(string(body), "graphTransactionByTxHash"):
+			return []byte(`{"data":{"graphTransactionByTxHash":{"txHash":"0x1"}}}`), nil
+		case uri.Host == "archive":
+			return []byte(`{"data":{"allEthHeaderCids":{"totalCount":1}}}`), nil
+		}
+		return []byte(`{"data":{"node":{"id":"abc"}}}`), nil
+	}
+
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(`{"query":"{ ethHeaderCidByBlockNumber(n: \"1\") { edges { node { cid } } } graphTransactionByTxHash(txHash: \"0x1\") { txHash } node(nodeId: \"abc\") { id } allEthHeaderCids { totalCount } broken { id } }"}`))
+	proxy.ServeHTTP(rr, r)
+
+	for host, want := range map[string]int{"default": 1, "tracing": 1, "archive": 2, "down": 1} {
+		if len(forwarded[host]) != want {
+			t.Errorf("Want: %d requests to %s, Got: %v", want, host, forwarded[host])
+		}
+	}
+	if body := strings.Join(forwarded["default"], ""); strings.Contains(body, "allEthHeaderCids") || strings.Contains(body, "broken") {
+		t.Errorf("Want: only node query in default, Got: %s", body)
+	}
+	result := fastjson.MustParseBytes(rr.Body.Bytes())
+	for _, path := range [][]string{
+		{"data", "ethHeaderCidByBlockNumber", "edges"},
+		{"data", "graphTransactionByTxHash", "txHash"},
+		{"data", "node", "id"},
+		{"data", "allEthHeaderCids", "totalCount"},
+	} {
+		if !result.Exists(path...) {
+			t.Errorf("Want: %v in %s", path, rr.Body.String())
+		}
+	}
+	if errs := result.GetArray("errors"); len(errs) != 1 || result.Get("data", "broken").Type() != fastjson.TypeNull ||
+		string(errs[0].GetStringBytes("path", "0")) != "broken" || string(errs[0].GetStringBytes("extensions", "code")) != CodeUpstream {
+		t.Errorf("Want: broken upstream error, Got: %s", rr.Body.String())
+	}
+	keys := make([]string, 0)
+	result.GetObject("data").Visit(func(key []byte, value *fastjson.Value) {
+		keys = append(keys, string(key))
+	})
+	if want := []string{"ethHeaderCidByBlockNumber", "graphTransactionByTxHash", "node", "allEthHeaderCids", "broken"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Want: keys in the order of the query %v, Got: %v", want, keys)
+	}
+
+	for i, routes := range [][]Route{
+		{{Field: "graph*", Upstream: "unknown"}},
+		{{Field: "[", Upstream: "archive"}},
+	} {
+		opts.Routes = routes
+		if err := newRouter(opts).validate(nil); err == nil {
+			t.Errorf("[%d] Want: error, Got: nil", i)
+		}
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	schemas := map[string]string{
+		"default": `{"queryType":{"name":"Query"},"types":[{"kind":"OBJECT","name":"Query","fields":[{"name":"ethHeaderCidByBlockNumber","args":[],"type":{"kind":"SCALAR","name":"String","ofType":null}},{"name":"status","args":[],"type":{"kind":"SCALAR","name":"String","ofType":null}}]},{"kind":"SCALAR","name":"String"}],"directives":[]}`,
+		"tracing": `{"queryType":{"name":"Query"},"types":[{"kind":"OBJECT","name":"Query","fields":[{"name":"graphTransactionByTxHash","args":[],"type":{"kind":"SCALAR","name":"String","ofType":null}},{"name":"status","args":[],"type":{"kind":"SCALAR","name":"Int","ofType":null}}]},{"kind":"SCALAR","name":"String"},{"kind":"SCALAR","name":"Int"}],"directives":[]}`,
+	}
+	proxy := NewHTTPReverseProxy(&Options{Postgraphile: PostgraphileOptions{
+		Default:    &url.URL{Scheme: "http", Host: "default"},
+		TracingAPI: &url.URL{Scheme: "http", Host: "tracing"},
+	}})
+	var introspections int32
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		if !strings.Contains(string(body), "IntrospectionQuery") {
+			t.Errorf("Want: introspection query, Got: %s", body)
+		}
+		atomic.AddInt32(&introspections, 1)
+		return []byte(`{"data":{"__schema":` + schemas[uri.Host] + `}}`), nil
+	}
+
+	// concurrent introspections read the same merged schema
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr := httptest.NewRecorder()
+			r, _ := http.NewRequest("POST", "/", strings.NewReader(`{"query":"query IntrospectionQuery { __schema { queryType { fields { name } } } }","operationName":"IntrospectionQuery"}`))
+			proxy.ServeHTTP(rr, r)
+			want := `{"data":{"__schema":{"queryType":{"fields":[{"name":"ethHeaderCidByBlockNumber"},{"name":"status"},{"name":"graphTransactionByTxHash"}]}}}}`
+			if rr.Body.String() != want {
+				t.Errorf("[%d] Want: %s, Got: %s", i, want, rr.Body.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+	// the merged schema is kept for its TTL
+	if n := atomic.LoadInt32(&introspections); n != 2 {
+		t.Errorf("Want: 2 introspections, Got: %d", n)
+	}
+
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/gapfill/schema", nil)
+	proxy.SchemaHandler().ServeHTTP(rr, r)
+	var status SchemaStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Upstreams["default"] != "ok" || status.Upstreams["tracing"] != "ok" ||
+		len(status.Conflicts) != 1 || status.Conflicts[0].Field != "status" || status.Conflicts[0].Kept != "default" {
+		t.Errorf("Want: status conflict, Got: %+v", status)
+	}
+}
+
+func TestSchemaFetch(t *testing.T) {
+	proxy := NewHTTPReverseProxy(&Options{
+		Postgraphile: PostgraphileOptions{
+			Default:    &url.URL{Scheme: "http", Host: "default"},
+			TracingAPI: &url.URL{Scheme: "http", Host: "tracing"},
+		},
+		Schema: SchemaOptions{TTL: time.Hour, RetryTTL: 50 * time.Millisecond},
+	})
+	var mu sync.Mutex
+	introspections := make(map[string]int)
+	var down int32 = 1
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		time.Sleep(20 * time.Millisecond)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		introspections[uri.Host]++
+		mu.Unlock()
+		if uri.Host == "tracing" && atomic.LoadInt32(&down) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return []byte(`{"data":{"__schema":{"queryType":{"name":"Query"},"types":[{"kind":"OBJECT","name":"Query","fields":[{"name":"` + uri.Host + `","args":[],"type":{"kind":"SCALAR","name":"String","ofType":null}}]}],"directives":[]}}}`), nil
+	}
+	fields := func(merged []byte) int {
+		return len(fastjson.MustParseBytes(merged).GetArray("types", "0", "fields"))
+	}
+	check := func(step string, want map[string]int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !reflect.DeepEqual(introspections, want) {
+			t.Errorf("%s Want: %v introspections, Got: %v", step, want, introspections)
+		}
+	}
+
+	// concurrent introspections share a single fetch
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			merged, status := proxy.mergedSchema(context.Background())
+			if merged == nil || fields(merged) != 1 || status.Upstreams["tracing"] == "ok" {
+				t.Errorf("Want: partial schema, Got: %s %+v", merged, status)
+			}
+		}()
+	}
+	wg.Wait()
+	check("concurrent", map[string]int{"default": 1, "tracing": 1})
+
+	// the partial schema is kept for the retry TTL
+	proxy.mergedSchema(context.Background())
+	check("retry TTL", map[string]int{"default": 1, "tracing": 1})
+
+	// a canceled client gets the stale schema, the fetch goes on
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&down, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if merged, _ := proxy.mergedSchema(ctx); merged == nil || fields(merged) != 1 {
+		t.Errorf("Want: stale schema, Got: %s", merged)
+	}
+	time.Sleep(60 * time.Millisecond)
+	merged, status := proxy.mergedSchema(context.Background())
+	if merged == nil || fields(merged) != 2 || status.Upstreams["tracing"] != "ok" {
+		t.Errorf("Want: full schema, Got: %s %+v", merged, status)
+	}
+	check("refetch", map[string]int{"default": 2, "tracing": 2})
+}
+
+func TestMixedFills(t *testing.T) {
+	proxy := NewHTTPReverseProxy(&Options{})
+	proxy.Register(NewEthHeaderCidByBlockNumberMockService())
+	proxy.Register(qlservices.NewReceiptCidsByTxHashService(nil))
+	proxy.forward = func(ctx context.Context, uri *url.URL, body []byte) ([]byte, error) {
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]},"receiptCidsByTxHash":{"nodes":[]}}}`), nil
+	}
+	proxy.polling = func(ctx context.Context, uri *url.URL, body []byte, isEmpty func(data []byte) (bool, error)) ([]byte, error) {
+		return []byte(`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"cursor":"x"}]}}}`), nil
+	}
+
+	// fills finishing at once race with forbidden parts of the same request
+	query := "query MyQuery {"
+	for i := 0; i < 20; i++ {
+		query += fmt.Sprintf(` h%d: ethHeaderCidByBlockNumber(n: \"%d\") { edges { cursor } } r%d: receiptCidsByTxHash(txHash: \"0x%d\") { nodes { cid } }`, i, i, i, i)
+	}
+	query += " }"
+	client := &auth.Client{ID: "filler", Fill: true, Services: []string{"ethHeaderCidByBlockNumber"}}
+	rr := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(`{"query":"`+query+`"}`))
+	proxy.ServeHTTP(rr, r.WithContext(auth.WithClient(r.Context(), client)))
+
+	body := fastjson.MustParseBytes(rr.Body.Bytes())
+	if n := len(body.GetArray("errors")); n != 20 {
+		t.Errorf("Want: 20 forbidden fills, Got: %s", body)
+	}
+	if !body.Exists("data", "h19", "edges", "0", "cursor") {
+		t.Errorf("Want: filled h19, Got: %s", body)
+	}
+}
+
+func TestDuplicateServices(t *testing.T) {
+	service := qlservices.ServiceConfig{Name: "tokenBalance", Arg: "address", Method: "statediff_writeStateDiffAt"}
+	for i, services := range [][]qlservices.ServiceConfig{
+		{{Name: "ethHeaderCidByBlockNumber", Arg: "n", Method: "statediff_writeStateDiffAt"}},
+		{service, service},
+	} {
+		if _, err := New(&Options{Services: services}); !errors.Is(err, qlservices.ErrBadConfig) {
+			t.Errorf("[%d] Want: %v, Got: %v", i, qlservices.ErrBadConfig, err)
+		}
+	}
+}
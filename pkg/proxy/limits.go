@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vulcanize/gap-filler/pkg/prom"
+	"golang.org/x/time/rate"
+)
+
+// List of errors
+var (
+	ErrRateLimited   = errors.New("gap-fill rate limit of the client is exceeded")
+	ErrOverloaded    = errors.New("too many gap-fills in flight")
+	ErrUnknownPolicy = errors.New("unknown limit policy")
+)
+
+// LimitPolicy decides what happens to gap-fills over the limits
+type LimitPolicy string
+
+// List of policies
+const (
+	// LimitQueue delay gap-fills until they fit the limits or the request is done
+	LimitQueue LimitPolicy = "queue"
+	// LimitReject fail gap-fills over the limits at once, the empty result
+	// is returned
+	LimitReject LimitPolicy = "reject"
+)
+
+// idle client buckets are dropped after this time, they are full by then
+const clientIdle = 10 * time.Minute
+
+type LimitsOptions struct {
+	// ClientRate is gap-fill calls per second of a single client, zero
+	// means no limit. Clients are identified by auth or remote address
+	ClientRate float64
+	// ClientBurst is the bucket size, it's at least 1
+	ClientBurst int
+	// MaxFills limits concurrent Service.Do calls, zero means no limit
+	MaxFills int
+	Policy   LimitPolicy
+}
+
+type bucket struct {
+	limiter *rate.Limiter
+	used    time.Time
+}
+
+// limits admits gap-fills by the token bucket of the client and the global
+// number of fills in flight
+type limits struct {
+	policy LimitPolicy
+	rate   rate.Limit
+	burst  int
+	slots  chan struct{}
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// validate the policy, empty one means LimitQueue
+func (policy LimitPolicy) validate() error {
+	switch policy {
+	case "", LimitQueue, LimitReject:
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownPolicy, policy)
+}
+
+func newLimits(opts LimitsOptions) *limits {
+	if opts.Policy == "" {
+		opts.Policy = LimitQueue
+	}
+	if opts.ClientBurst < 1 {
+		opts.ClientBurst = 1
+	}
+	l := &limits{
+		policy:  opts.Policy,
+		rate:    rate.Limit(opts.ClientRate),
+		burst:   opts.ClientBurst,
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+	}
+	if opts.MaxFills > 0 {
+		l.slots = make(chan struct{}, opts.MaxFills)
+	}
+	return l
+}
+
+func (l *limits) bucket(client string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.swept) > clientIdle {
+		for id, b := range l.buckets {
+			if now.Sub(b.used) > clientIdle {
+				delete(l.buckets, id)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.buckets[client] = b
+	}
+	b.used = now
+	return b.limiter
+}
+
+// admit take a token of the client and check there is room for one more
+// fill. The queue policy waits for the token until the context is done,
+// wait false rejects over the rate anyway, e.g. for async submits which
+// can't hold the response
+func (l *limits) admit(ctx context.Context, wait bool) error {
+	reject := l.policy == LimitReject
+	wait = wait && !reject
+	if l.rate > 0 {
+		limiter := l.bucket(clientID(ctx))
+		if !limiter.Allow() {
+			if !wait {
+				prom.FillLimited("client_rate", "rejected")
+				return ErrRateLimited
+			}
+			prom.FillLimited("client_rate", "queued")
+			if err := limiter.Wait(ctx); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				// the token isn't available before the deadline
+				return fmt.Errorf("%w: %s", ErrRateLimited, err)
+			}
+		}
+	}
+	// admitted fills queue up for a slot in runJob
+	if l.slots != nil && reject && len(l.slots) == cap(l.slots) {
+		prom.FillLimited("max_fills", "rejected")
+		return ErrOverloaded
+	}
+	return nil
+}
+
+// acquire a slot for the Service.Do call, calls over the limit wait in the
+// job queue regardless of the policy since the job is already admitted
+func (l *limits) acquire(ctx context.Context) (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		prom.FillLimited("max_fills", "queued")
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return func() { <-l.slots }, nil
+}
+
+type clientKey struct{}
+
+// withClientID returns the context carrying the id of the client bucket
+func withClientID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, clientKey{}, id)
+}
+
+func clientID(ctx context.Context) string {
+	id, _ := ctx.Value(clientKey{}).(string)
+	return id
+}
@@ -1,43 +1,144 @@
 package proxy
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
-	"github.com/ethereum/go-ethereum/rpc"
-
+	"github.com/vulcanize/gap-filler/pkg/jobs"
 	"github.com/vulcanize/gap-filler/pkg/qlservices"
+	"github.com/vulcanize/gap-filler/pkg/rpcpool"
 )
 
 // Proxy accept http and ws requests
 type Proxy struct {
 	wsProxy   http.Handler
-	httpProxy http.Handler
+	httpProxy *HTTPReverseProxy
 }
 
 type PostgraphileOptions struct {
+	// Default and TracingAPI are the "default" and "tracing" upstreams
+	// unless Upstreams override them
 	Default    *url.URL
 	TracingAPI *url.URL
+	// Upstreams are named postgraphile endpoints
+	Upstreams map[string]*url.URL
+	// Routes of root fields to upstreams, the first matching route wins.
+	// Fields without a route go to the upstream of their service or to
+	// the default one
+	Routes []Route
 }
 
 type RPCOptions struct {
-	DefaultClients []*rpc.Client
-	TracingClients []*rpc.Client
+	Default *rpcpool.Pool
+	Tracing *rpcpool.Pool
+}
+
+type JobsOptions struct {
+	// Store keeps jobs, in-memory store is used when it's nil. The store
+	// isn't closed by the proxy, its owner closes it after Shutdown
+	Store jobs.Store
+	Queue jobs.Options
+}
+
+// Notifier wakes polling up when new rows are indexed
+type Notifier interface {
+	Subscribe() (<-chan struct{}, func())
+}
+
+type NotifyOptions struct {
+	// Notifier is optional, polling relies on the ticker only when it's nil
+	Notifier Notifier
+	// Fallback is the polling period when notifier is used
+	Fallback time.Duration
+	// MinInterval between postgraphile requests of a single polling, a burst
+	// of notifications results in one request per interval
+	MinInterval time.Duration
 }
 
 type Options struct {
+	// Context is the server lifetime, gap-fill jobs are canceled when it's done
+	Context      context.Context
 	Postgraphile PostgraphileOptions
 	RPC          RPCOptions
+	Services     []qlservices.ServiceConfig
+	// RangeWorkers limits parallel fills of a single block range query
+	RangeWorkers int
+	Jobs         JobsOptions
+	Notify       NotifyOptions
+	// ForwardAuthorization passes the Authorization header of the client
+	// to postgraphile
+	ForwardAuthorization bool
+	Limits               LimitsOptions
+	Cache                CacheOptions
+	Failures             FailuresOptions
+	Persisted            PersistedOptions
+	Headers              HeadersOptions
+	Schema               SchemaOptions
 }
 
 // New create new router
-func New(opts *Options) *Proxy {
-	return &Proxy{
-		wsProxy: NewWebsocketReverseProxy(opts.Postgraphile.Default),
-		httpProxy: NewHTTPReverseProxy(opts).
-			Register(qlservices.NewEthHeaderCidByBlockNumberService(opts.RPC.DefaultClients)).
-			Register(qlservices.NewGetGraphCallByTxHashService(opts.RPC.TracingClients)),
+func New(opts *Options) (*Proxy, error) {
+	if err := opts.Limits.Policy.validate(); err != nil {
+		return nil, err
+	}
+	httpProxy := NewHTTPReverseProxy(opts).
+		Register(qlservices.NewEthHeaderCidByBlockNumberService(opts.RPC.Default)).
+		Register(qlservices.NewEthHeaderCidByBlockHashService(opts.RPC.Default)).
+		Register(qlservices.NewEthTransactionCidByTxHashService(opts.RPC.Default)).
+		Register(qlservices.NewReceiptCidsByTxHashService(opts.RPC.Default)).
+		Register(qlservices.NewAllEthHeaderCidsService(opts.RPC.Default)).
+		Register(qlservices.NewGetGraphCallByTxHashService(opts.RPC.Tracing))
+
+	for _, cfg := range opts.Services {
+		if _, ok := httpProxy.services[cfg.Name]; ok {
+			return nil, fmt.Errorf("service %s: duplicate name: %w", cfg.Name, qlservices.ErrBadConfig)
+		}
+		pool := opts.RPC.Default
+		if cfg.RPC == qlservices.RPCTracing {
+			pool = opts.RPC.Tracing
+		}
+		srv, err := qlservices.NewGenericService(cfg, pool)
+		if err != nil {
+			return nil, err
+		}
+		httpProxy.Register(srv)
+	}
+
+	if err := httpProxy.router.validate(httpProxy.services); err != nil {
+		return nil, err
 	}
+
+	if err := httpProxy.Resume(); err != nil {
+		return nil, err
+	}
+
+	return &Proxy{
+		wsProxy:   NewWebsocketReverseProxy(httpProxy.upstreamURI(qlservices.PostgraphileDefault)),
+		httpProxy: httpProxy,
+	}, nil
+}
+
+// JobsHandler serve state of gap-fill jobs
+func (p *Proxy) JobsHandler() http.Handler {
+	return p.httpProxy.JobsHandler()
+}
+
+// FailuresHandler serve memoized gap-fill failures
+func (p *Proxy) FailuresHandler() http.Handler {
+	return p.httpProxy.FailuresHandler()
+}
+
+// SchemaHandler serve the status of the merged schema
+func (p *Proxy) SchemaHandler() http.Handler {
+	return p.httpProxy.SchemaHandler()
+}
+
+// Shutdown drain gap-fill jobs, see HTTPReverseProxy.Shutdown
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	return p.httpProxy.Shutdown(ctx)
 }
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
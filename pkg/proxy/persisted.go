@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/valyala/fastjson"
+	"github.com/vulcanize/gap-filler/pkg/cache"
+)
+
+// Codes of automatic persisted query errors, they follow apollo so clients
+// register the query on PERSISTED_QUERY_NOT_FOUND
+const (
+	CodePersistedQueryNotFound     = "PERSISTED_QUERY_NOT_FOUND"
+	CodePersistedQueryNotSupported = "PERSISTED_QUERY_NOT_SUPPORTED"
+	CodeBadRequest                 = "BAD_REQUEST"
+)
+
+// List of errors
+var (
+	ErrPersistedQueryNotFound     = errors.New("PersistedQueryNotFound")
+	ErrPersistedQueryNotSupported = errors.New("PersistedQueryNotSupported")
+	ErrPersistedQueryVersion      = errors.New("unsupported persisted query version")
+	ErrHashMismatch               = errors.New("provided sha does not match query")
+	ErrMethodNotAllowed           = errors.New("only queries are allowed over GET")
+)
+
+type PersistedOptions struct {
+	// Store keeps queries by their sha256 hash, automatic persisted queries
+	// aren't supported when it's nil
+	Store cache.Cache
+}
+
+// getRequest build the JSON request of GET /graphql?query=...&variables=...
+// variables and extensions are JSON encoded parameters
+func getRequest(query url.Values) ([]byte, error) {
+	arena := new(fastjson.Arena)
+	obj := arena.NewObject()
+	if query.Has("query") {
+		obj.Set("query", arena.NewString(query.Get("query")))
+	}
+	for _, name := range []string{"variables", "extensions"} {
+		raw := query.Get(name)
+		if raw == "" {
+			continue
+		}
+		value, err := fastjson.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s: %w", name, err)
+		}
+		obj.Set(name, value)
+	}
+	if name := query.Get("operationName"); name != "" {
+		obj.Set("operationName", arena.NewString(name))
+	}
+	return obj.MarshalTo(nil), nil
+}
+
+// persisted resolve the automatic persisted query. The query is taken from
+// the store by `extensions.persistedQuery.sha256Hash`, the request carrying
+// both the query and the hash registers the query. The extension is removed,
+// so the result is a regular request
+func (handler *HTTPReverseProxy) persisted(request []byte) ([]byte, error) {
+	req, err := fastjson.ParseBytes(request)
+	if err != nil || req.Type() != fastjson.TypeObject {
+		// postgraphile reports malformed requests itself
+		return request, nil
+	}
+	pq := req.Get("extensions", "persistedQuery")
+	if pq == nil {
+		return request, nil
+	}
+	if handler.persistedStore == nil {
+		return nil, ErrPersistedQueryNotSupported
+	}
+	if version := pq.GetInt("version"); version != 1 {
+		return nil, fmt.Errorf("%w: %d", ErrPersistedQueryVersion, version)
+	}
+	hash := strings.ToLower(string(pq.GetStringBytes("sha256Hash")))
+
+	if req.Exists("query") {
+		query := req.GetStringBytes("query")
+		sum := sha256.Sum256(query)
+		if hex.EncodeToString(sum[:]) != hash {
+			return nil, ErrHashMismatch
+		}
+		handler.persistedStore.Set(hash, append([]byte(nil), query...))
+	} else {
+		query, ok := handler.persistedStore.Get(hash)
+		if !ok {
+			return nil, ErrPersistedQueryNotFound
+		}
+		req.Set("query", new(fastjson.Arena).NewStringBytes(query))
+	}
+
+	extensions := req.Get("extensions")
+	extensions.Del("persistedQuery")
+	if extensions.GetObject().Len() == 0 {
+		req.Del("extensions")
+	}
+	return req.MarshalTo(nil), nil
+}
+
+// requestErrorCode of errors which prevent the request execution
+func requestErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrPersistedQueryNotFound):
+		return CodePersistedQueryNotFound
+	case errors.Is(err, ErrPersistedQueryNotSupported):
+		return CodePersistedQueryNotSupported
+	}
+	return CodeBadRequest
+}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"fmt"
+
+	"github.com/valyala/fastjson"
+)
+
+// Codes of gap-filler own errors, they are set as `extensions.code`
+const (
+	CodeUpstream    = "UPSTREAM_ERROR"
+	CodeFillFailed  = "GAP_FILL_FAILED"
+	CodeFillTimeout = "GAP_FILL_TIMEOUT"
+	// CodeFillForbidden is reported when the client isn't allowed to fill
+	// the empty result
+	CodeFillForbidden = "GAP_FILL_FORBIDDEN"
+	// CodeRateLimited and CodeOverloaded are reported when the gap-fill is
+	// rejected by the limits
+	CodeRateLimited = "GAP_FILL_RATE_LIMITED"
+	CodeOverloaded  = "GAP_FILL_OVERLOADED"
+	// CodeKnownFailure is reported when the gap-fill is skipped since it
+	// failed recently
+	CodeKnownFailure = "GAP_FILL_KNOWN_FAILURE"
+)
+
+// response merges results of the split documents into a single graphql
+// response. Failed parts are reported in `errors` with the path of their
+// response key, the rest of `data` is kept
+type response struct {
+	arena  fastjson.Arena
+	data   *fastjson.Value
+	errors *fastjson.Value
+	length int
+	ext    *fastjson.Value
+	// hasData is false when the request wasn't executed at all
+	hasData bool
+}
+
+func newResponse() *response {
+	resp := new(response)
+	resp.data = resp.arena.NewObject()
+	resp.errors = resp.arena.NewArray()
+	return resp
+}
+
+// merge the default document result, its fields and errors are kept as is
+func (resp *response) merge(body []byte) {
+	result, err := fastjson.ParseBytes(body)
+	if err != nil {
+		resp.addError(fmt.Sprintf("bad postgraphile response: %s", err), CodeUpstream)
+		return
+	}
+	if data := result.GetObject("data"); data != nil {
+		resp.hasData = true
+		data.Visit(func(key []byte, value *fastjson.Value) {
+			resp.data.Set(string(key), value)
+		})
+	}
+	for _, item := range result.GetArray("errors") {
+		resp.appendError(item)
+	}
+}
+
+// mergePart put the result of the split document under the response key.
+// The split document has no alias, so the result and error paths are under
+// the field name
+func (resp *response) mergePart(key, name string, body []byte) {
+	resp.hasData = true
+	result, err := fastjson.ParseBytes(body)
+	if err != nil {
+		resp.data.Set(key, resp.arena.NewNull())
+		resp.addError(fmt.Sprintf("bad postgraphile response: %s", err), CodeUpstream, key)
+		return
+	}
+	value := result.Get("data", name)
+	if value == nil {
+		value = resp.arena.NewNull()
+	}
+	resp.data.Set(key, value)
+	for _, item := range result.GetArray("errors") {
+		if path := item.GetArray("path"); len(path) > 0 && string(path[0].GetStringBytes()) == name {
+			path[0] = resp.arena.NewString(key)
+			list := resp.arena.NewArray()
+			for i := range path {
+				list.SetArrayItem(i, path[i])
+			}
+			item.Set("path", list)
+		}
+		resp.appendError(item)
+	}
+}
+
+// order `data` by the response keys, keys missing in the list go last in
+// the order they were merged
+func (resp *response) order(keys []string) {
+	data := resp.arena.NewObject()
+	for _, key := range keys {
+		if value := resp.data.Get(key); value != nil {
+			data.Set(key, value)
+		}
+	}
+	resp.data.GetObject().Visit(func(key []byte, value *fastjson.Value) {
+		if data.Get(string(key)) == nil {
+			data.Set(string(key), value)
+		}
+	})
+	resp.data = data
+}
+
+// addError report gap-filler own error, the path is the response key
+func (resp *response) addError(message, code string, path ...string) {
+	if len(path) > 0 {
+		resp.hasData = true
+	}
+	item := resp.arena.NewObject()
+	item.Set("message", resp.arena.NewString(message))
+	if len(path) > 0 {
+		list := resp.arena.NewArray()
+		for i := range path {
+			list.SetArrayItem(i, resp.arena.NewString(path[i]))
+		}
+		item.Set("path", list)
+	}
+	extensions := resp.arena.NewObject()
+	extensions.Set("code", resp.arena.NewString(code))
+	item.Set("extensions", extensions)
+	resp.appendError(item)
+}
+
+func (resp *response) appendError(item *fastjson.Value) {
+	resp.errors.SetArrayItem(resp.length, item)
+	resp.length++
+}
+
+func (resp *response) setExtensions(ext *fastjson.Value) {
+	resp.ext = ext
+}
+
+// Bytes of the response, `errors` is omitted when there are none
+func (resp *response) Bytes() []byte {
+	obj := resp.arena.NewObject()
+	if resp.length > 0 {
+		obj.Set("errors", resp.errors)
+	}
+	if resp.hasData {
+		obj.Set("data", resp.data)
+	}
+	if resp.ext != nil {
+		obj.Set("extensions", resp.ext)
+	}
+	return obj.MarshalTo(nil)
+}
+
+// errorResponse is the response to a request which can't be executed at all
+func errorResponse(message string) []byte {
+	arena := new(fastjson.Arena)
+	item := arena.NewObject()
+	item.Set("message", arena.NewString(message))
+	list := arena.NewArray()
+	list.SetArrayItem(0, item)
+	obj := arena.NewObject()
+	obj.Set("errors", list)
+	return obj.MarshalTo(nil)
+}
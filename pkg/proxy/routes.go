@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/vulcanize/gap-filler/pkg/qlservices"
+)
+
+// ErrUnknownUpstream is returned when a route or a service refers to the
+// upstream which isn't configured
+var ErrUnknownUpstream = errors.New("unknown postgraphile upstream")
+
+// Route sends root fields matching the pattern to the upstream
+//
+//	[[gql.routes]]
+//	field = "graph*"
+//	upstream = "tracing"
+type Route struct {
+	// Field is the root field name or path.Match pattern
+	Field    string `mapstructure:"field"`
+	Upstream string `mapstructure:"upstream"`
+}
+
+// router chooses the postgraphile upstream of root fields. Routes are checked
+// in order, then the upstream of the service, then the default upstream
+type router struct {
+	upstreams map[string]*url.URL
+	routes    []Route
+}
+
+func newRouter(opts PostgraphileOptions) *router {
+	upstreams := make(map[string]*url.URL)
+	if opts.Default != nil {
+		upstreams[qlservices.PostgraphileDefault] = opts.Default
+	}
+	if opts.TracingAPI != nil {
+		upstreams[qlservices.PostgraphileTracing] = opts.TracingAPI
+	}
+	for name, uri := range opts.Upstreams {
+		upstreams[name] = uri
+	}
+	return &router{upstreams: upstreams, routes: opts.Routes}
+}
+
+// validate routes and upstreams of the services
+func (rt *router) validate(services map[string]Service) error {
+	if _, ok := rt.upstreams[qlservices.PostgraphileDefault]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownUpstream, qlservices.PostgraphileDefault)
+	}
+	for _, route := range rt.routes {
+		if _, err := path.Match(route.Field, ""); err != nil {
+			return fmt.Errorf("route %s: %w", route.Field, err)
+		}
+		if _, ok := rt.upstreams[route.Upstream]; !ok {
+			return fmt.Errorf("route %s: %w: %s", route.Field, ErrUnknownUpstream, route.Upstream)
+		}
+	}
+	for name, srv := range services {
+		if srv, ok := srv.(Upstream); ok {
+			if _, ok := rt.upstreams[srv.Postgraphile()]; !ok {
+				return fmt.Errorf("service %s: %w: %s", name, ErrUnknownUpstream, srv.Postgraphile())
+			}
+		}
+	}
+	return nil
+}
+
+// upstream name of the root field, empty field is a root selection which
+// isn't a field and goes to the default upstream
+func (handler *HTTPReverseProxy) upstream(field string) string {
+	if field == "" {
+		return qlservices.PostgraphileDefault
+	}
+	for _, route := range handler.router.routes {
+		if ok, _ := path.Match(route.Field, field); ok {
+			return route.Upstream
+		}
+	}
+	if srv, ok := handler.services[field].(Upstream); ok {
+		if _, ok := handler.router.upstreams[srv.Postgraphile()]; ok {
+			return srv.Postgraphile()
+		}
+	}
+	return qlservices.PostgraphileDefault
+}
+
+// upstreamURI of the upstream name, the default one if it's unknown
+func (handler *HTTPReverseProxy) upstreamURI(name string) *url.URL {
+	if uri, ok := handler.router.upstreams[name]; ok {
+		return uri
+	}
+	return handler.router.upstreams[qlservices.PostgraphileDefault]
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fastjson"
+	"github.com/vulcanize/gap-filler/pkg/qlservices"
+	"github.com/vulcanize/gap-filler/pkg/schema"
+	"golang.org/x/sync/singleflight"
+)
+
+type SchemaOptions struct {
+	// TTL of the merged schema, upstreams are introspected again after it
+	TTL time.Duration
+	// RetryTTL of the schema merged while some upstreams failed, they are
+	// introspected again sooner than TTL
+	RetryTTL time.Duration
+}
+
+// SchemaStatus of the merged schema
+type SchemaStatus struct {
+	// Upstreams are introspected upstreams with the error if it failed
+	Upstreams map[string]string `json:"upstreams"`
+	Conflicts []schema.Conflict `json:"conflicts"`
+	Fetched   time.Time         `json:"fetched"`
+}
+
+// unifiedSchema is the merged schema of all upstreams. It's fetched by a
+// single goroutine at a time with the server context, so a canceled client
+// request doesn't fail the fetch for the others
+type unifiedSchema struct {
+	ctx   context.Context
+	ttl   time.Duration
+	retry time.Duration
+	group singleflight.Group
+
+	mu sync.Mutex
+	// schema is the marshaled merged schema, every request parses its own
+	schema  []byte
+	status  SchemaStatus
+	expires time.Time
+}
+
+type mergeResult struct {
+	schema []byte
+	status SchemaStatus
+}
+
+func newUnifiedSchema(ctx context.Context, opts SchemaOptions) *unifiedSchema {
+	if opts.TTL <= 0 {
+		opts.TTL = 5 * time.Minute
+	}
+	if opts.RetryTTL <= 0 {
+		opts.RetryTTL = 10 * time.Second
+	}
+	if opts.RetryTTL > opts.TTL {
+		opts.RetryTTL = opts.TTL
+	}
+	return &unifiedSchema{ctx: ctx, ttl: opts.TTL, retry: opts.RetryTTL}
+}
+
+// upstreamNames with distinct addresses, default first and the rest by name
+func (handler *HTTPReverseProxy) upstreamNames() []string {
+	names := make([]string, 0, len(handler.router.upstreams))
+	for name := range handler.router.upstreams {
+		if name != qlservices.PostgraphileDefault {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{qlservices.PostgraphileDefault}, names...)
+
+	seen := make(map[string]bool)
+	distinct := make([]string, 0, len(names))
+	for _, name := range names {
+		uri := handler.upstreamURI(name)
+		if uri == nil || seen[uri.String()] {
+			continue
+		}
+		seen[uri.String()] = true
+		distinct = append(distinct, name)
+	}
+	return distinct
+}
+
+// mergedSchema returns the merged schema, it's fetched when it's expired.
+// Waiting for the fetch stops when the context is done, the stale schema is
+// returned then if there is one
+func (handler *HTTPReverseProxy) mergedSchema(ctx context.Context) ([]byte, SchemaStatus) {
+	us := handler.schema
+	us.mu.Lock()
+	merged, status, expires := us.schema, us.status, us.expires
+	us.mu.Unlock()
+	if time.Now().Before(expires) {
+		return merged, status
+	}
+
+	ch := us.group.DoChan("schema", func() (interface{}, error) {
+		return handler.fetchSchema(), nil
+	})
+	select {
+	case res := <-ch:
+		result := res.Val.(mergeResult)
+		return result.schema, result.status
+	case <-ctx.Done():
+		return merged, status
+	}
+}
+
+// fetchSchema introspect upstreams and merge their schemas. The schema is
+// shared by clients, so upstreams get only static headers. The schema merged
+// while some upstreams failed is kept for the retry TTL, the previous schema
+// is kept if all of them failed
+func (handler *HTTPReverseProxy) fetchSchema() mergeResult {
+	us := handler.schema
+	ctx := withOutgoingHeader(us.ctx, handler.headers.static.Clone())
+	names := handler.upstreamNames()
+	schemas := make([]*fastjson.Value, len(names))
+	errs := make([]error, len(names))
+	wg := new(sync.WaitGroup)
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, err := handler.forward(ctx, handler.upstreamURI(names[i]), schema.Request())
+			if err == nil {
+				schemas[i], err = schema.Parse(data)
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+
+	status := SchemaStatus{
+		Upstreams: make(map[string]string),
+		Conflicts: make([]schema.Conflict, 0),
+		Fetched:   time.Now(),
+	}
+	sources := make([]schema.Source, 0, len(names))
+	ttl := us.ttl
+	for i, name := range names {
+		if errs[i] != nil {
+			logrus.WithError(errs[i]).Warnf("couldn't introspect %s postgraphile", name)
+			status.Upstreams[name] = errs[i].Error()
+			ttl = us.retry
+			continue
+		}
+		status.Upstreams[name] = "ok"
+		sources = append(sources, schema.Source{Upstream: name, Schema: schemas[i]})
+	}
+	merged, conflicts := schema.Merge(sources, handler.upstream)
+	for _, conflict := range conflicts {
+		logrus.Warnf("schema conflict %s", conflict)
+	}
+	status.Conflicts = append(status.Conflicts, conflicts...)
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	data := us.schema
+	if merged != nil {
+		data = merged.MarshalTo(nil)
+	}
+	us.schema, us.status = data, status
+	us.expires = time.Now().Add(ttl)
+	return mergeResult{data, status}
+}
+
+// introspect answer the introspection query from the merged schema, the
+// request goes to the default upstream if no upstream could be introspected
+func (handler *HTTPReverseProxy) introspect(ctx context.Context, request []byte) ([]byte, error) {
+	merged, _ := handler.mergedSchema(ctx)
+	if merged == nil {
+		return handler.forward(ctx, handler.upstreamURI(qlservices.PostgraphileDefault), request)
+	}
+	return schema.Execute(merged, request)
+}
+
+// SchemaHandler serve the status of the merged schema: introspected upstreams
+// and conflicts of their schemas
+func (handler *HTTPReverseProxy) SchemaHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		_, status := handler.mergedSchema(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 // WebsocketReverseProxy it will not work with a regular HTTP request,
@@ -145,6 +146,8 @@ func (p *WebsocketReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request
 	}
 	defer nc.Close() // must close the underlying net connection after hijacking
 	defer d.Close()
+	// server read and write timeouts don't apply to long-lived websockets
+	nc.SetDeadline(time.Time{})
 
 	// write the modified incoming request to the dialed connection
 	err = outreq.Write(d)
@@ -159,7 +162,10 @@ func (p *WebsocketReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request
 	}
 	go cp(d, nc)
 	go cp(nc, d)
-	<-errc
+	select {
+	case <-errc:
+	case <-r.Context().Done():
+	}
 }
 
 // IsWebSocketRequest returns a boolean indicating whether the request has the
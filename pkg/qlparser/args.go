@@ -0,0 +1,85 @@
+package qlparser
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/printer"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// PrintArgs print graphql arguments sorted by name, fields of input objects
+// are sorted too, so equal arguments always give the same string. Strings
+// are escaped, the printer of graphql-go writes them as is
+func PrintArgs(args []*ast.Argument) string {
+	printed := make([]string, 0, len(args))
+	for i := range args {
+		arg := ast.NewArgument(&ast.Argument{
+			Name:  args[i].Name,
+			Value: canonicalValue(args[i].Value),
+		})
+		printed = append(printed, printer.Print(arg).(string))
+	}
+	sort.Strings(printed)
+	return strings.Join(printed, ", ")
+}
+
+// canonicalValue copy the value with object fields sorted by name and
+// strings escaped, the order of list items is kept
+func canonicalValue(value ast.Value) ast.Value {
+	switch value := value.(type) {
+	case *ast.StringValue:
+		return ast.NewStringValue(&ast.StringValue{Value: escapeString(value.Value)})
+	case *ast.ObjectValue:
+		fields := make([]*ast.ObjectField, len(value.Fields))
+		for i, field := range value.Fields {
+			fields[i] = ast.NewObjectField(&ast.ObjectField{
+				Name:  field.Name,
+				Value: canonicalValue(field.Value),
+			})
+		}
+		sort.SliceStable(fields, func(i, j int) bool {
+			return fields[i].Name.Value < fields[j].Name.Value
+		})
+		return ast.NewObjectValue(&ast.ObjectValue{Fields: fields})
+	case *ast.ListValue:
+		values := make([]ast.Value, len(value.Values))
+		for i := range value.Values {
+			values[i] = canonicalValue(value.Values[i])
+		}
+		return ast.NewListValue(&ast.ListValue{Values: values})
+	}
+	return value
+}
+
+// escapeString returns the content of the graphql string literal, JSON
+// escapes are valid in graphql strings
+func escapeString(value string) string {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(value)
+	quoted := bytes.TrimSpace(buf.Bytes())
+	return string(quoted[1 : len(quoted)-1])
+}
+
+// ParseArgs parse arguments printed with PrintArgs
+func ParseArgs(args string) ([]*ast.Argument, error) {
+	if args == "" {
+		return nil, nil
+	}
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: []byte("{ f(" + args + ") }"),
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	op := doc.Definitions[0].(*ast.OperationDefinition)
+	return op.SelectionSet.Selections[0].(*ast.Field).Arguments, nil
+}
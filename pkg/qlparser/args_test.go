@@ -0,0 +1,42 @@
+package qlparser
+
+import (
+	"testing"
+)
+
+func TestPrintArgs(t *testing.T) {
+	queries := []string{
+		`query MyQuery { allEthHeaderCids(first: 10, filter: {blockNumber: {lessThan: "20", greaterThan: "10"}}) }`,
+		`query MyQuery {
+			allEthHeaderCids(
+				filter: {blockNumber: {lessThan: "20", greaterThan: "10"}}
+				first: 10
+			)
+		}`,
+		`query MyQuery { allEthHeaderCids(filter: {blockNumber: {greaterThan: "10", lessThan: "20"}}, first: 10) }`,
+	}
+	want := `filter: {blockNumber: {greaterThan: "10", lessThan: "20"}}, first: 10`
+	for i, query := range queries {
+		args, err := GetParams([]byte(query), "allEthHeaderCids")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := PrintArgs(args); got != want {
+			t.Errorf("[%d] Want: %s, Got: %s", i, want, got)
+		}
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	printed := `filter: {blockNumber: {greaterThan: "10", lessThan: "20"}}, first: 10`
+	args, err := ParseArgs(printed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 2 {
+		t.Fatalf("Want: 2 args, Got: %d", len(args))
+	}
+	if got := PrintArgs(args); got != printed {
+		t.Errorf("Want: %s, Got: %s", printed, got)
+	}
+}
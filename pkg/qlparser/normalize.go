@@ -0,0 +1,68 @@
+package qlparser
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/valyala/fastjson"
+)
+
+// Normalize returns the canonical form of the graphql request. Requests
+// which differ only in formatting, unused variables or the order of keys
+// in variables have the same form
+func Normalize(request []byte) ([]byte, error) {
+	req, err := fastjson.ParseBytes(request)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: req.GetStringBytes("query"),
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	variables := req.Get("variables")
+	if variables != nil && variables.Type() == fastjson.TypeString {
+		if variables, err = fastjson.ParseBytes(variables.GetStringBytes()); err != nil {
+			return nil, err
+		}
+	}
+	used := make(map[string]interface{})
+	for i := range doc.Definitions {
+		op, ok := doc.Definitions[i].(*ast.OperationDefinition)
+		if !ok || variables == nil {
+			continue
+		}
+		for _, def := range op.VariableDefinitions {
+			name := def.Variable.Name.Value
+			value := variables.Get(name)
+			if value == nil {
+				continue
+			}
+			// numbers are kept as is, maps are marshaled with sorted keys
+			var v interface{}
+			dec := json.NewDecoder(bytes.NewReader(value.MarshalTo(nil)))
+			dec.UseNumber()
+			if err := dec.Decode(&v); err != nil {
+				return nil, err
+			}
+			used[name] = v
+		}
+	}
+
+	return json.Marshal(struct {
+		Query         string                 `json:"query"`
+		Variables     map[string]interface{} `json:"variables"`
+		OperationName string                 `json:"operationName"`
+	}{
+		Query:         printEscaped(doc),
+		Variables:     used,
+		OperationName: string(req.GetStringBytes("operationName")),
+	})
+}
@@ -0,0 +1,31 @@
+package qlparser
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	base := `{"query":"query Q($n: BigInt!, $f: Boolean) { ethHeaderCidByBlockNumber(n: $n) { nodes { blockHash } } }","variables":{"n":"1","f":{"a":1,"b":2}},"operationName":"Q"}`
+	want, err := Normalize([]byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		request string
+		same    bool
+	}{
+		{`{"operationName":"Q","query":"query Q($n: BigInt!, $f: Boolean) {\n  ethHeaderCidByBlockNumber(n: $n) {\n    nodes { blockHash }\n  }\n}","variables":{"f":{"b":2,"a":1},"n":"1","unused":true}}`, true},
+		{`{"query":"query Q($n: BigInt!, $f: Boolean) { ethHeaderCidByBlockNumber(n: $n) { nodes { blockHash } } }","variables":"{\"n\":\"1\",\"f\":{\"a\":1,\"b\":2}}","operationName":"Q"}`, true},
+		{`{"query":"query Q($n: BigInt!, $f: Boolean) { ethHeaderCidByBlockNumber(n: $n) { nodes { blockHash } } }","variables":{"n":"2","f":{"a":1,"b":2}},"operationName":"Q"}`, false},
+		{`{"query":"query Q($n: BigInt!, $f: Boolean) { ethHeaderCidByBlockNumber(n: $n) { nodes { blockHash parentHash } } }","variables":{"n":"1","f":{"a":1,"b":2}},"operationName":"Q"}`, false},
+	} {
+		got, err := Normalize([]byte(tc.request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (string(got) == string(want)) != tc.same {
+			t.Errorf("Want: same %v as %s, Got: %s", tc.same, want, got)
+		}
+	}
+}
@@ -5,9 +5,7 @@ import (
 
 	"github.com/graphql-go/graphql/language/ast"
 	"github.com/graphql-go/graphql/language/parser"
-	"github.com/graphql-go/graphql/language/printer"
 	"github.com/graphql-go/graphql/language/source"
-	"github.com/jinzhu/copier"
 	"github.com/valyala/fastjson"
 )
 
@@ -17,13 +15,190 @@ var (
 	ErrBadType  = errors.New("Bad type")
 )
 
-// QuerySplit split graphQL document by query names
-func QuerySplit(request []byte, names []string) ([]byte, map[string][]byte, error) {
-	req, err := fastjson.ParseBytes(request)
+// Query is a root field of the request split into its own document
+type Query struct {
+	// Name of the field
+	Name string
+	// Doc is the graphql request of the field. The alias is removed, so the
+	// result is under the field name
+	Doc []byte
+}
+
+// QuerySplit split the operation chosen by operationName by root fields of
+// the given names. Queries are keyed by the response key (alias or name),
+// fields of root level fragments are split as well. Every document carries
+// only the fragments and variables it uses. The rest of the operation is
+// returned as the default document, it's nil if nothing is left
+func QuerySplit(request []byte, names []string) ([]byte, map[string]Query, error) {
+	op, err := parseOperation(request)
 	if err != nil {
 		return nil, nil, err
 	}
-	arena := new(fastjson.Arena)
+	// ambiguous or unknown operation is left to postgraphile to report
+	if op.def == nil || op.def.Operation != "query" {
+		return request, map[string]Query{}, nil
+	}
+
+	index := make(map[string]bool)
+	for i := range names {
+		index[names[i]] = true
+	}
+
+	fields := make(map[string][]ast.Selection)
+	queries := make(map[string]Query)
+	rest := make([]ast.Selection, 0)
+	for _, selection := range op.selections() {
+		field, ok := selection.(*ast.Field)
+		if !ok || !index[field.Name.Value] {
+			rest = append(rest, selection)
+			continue
+		}
+		key := responseKey(field)
+		unaliased := *field
+		unaliased.Alias = nil
+		fields[key] = append(fields[key], &unaliased)
+		queries[key] = Query{Name: field.Name.Value}
+	}
+	if len(queries) == 0 {
+		return request, queries, nil
+	}
+	for key, query := range queries {
+		query.Doc = op.build(fields[key])
+		queries[key] = query
+	}
+
+	var defDoc []byte
+	if len(rest) > 0 {
+		defDoc = op.build(rest)
+	}
+	return defDoc, queries, nil
+}
+
+// Group is a part of the request sent to a single upstream
+type Group struct {
+	// Name of the group
+	Name string
+	// Keys are response keys of root fields of the group
+	Keys []string
+	// Doc is the graphql request of the group
+	Doc []byte
+}
+
+// GroupSplit split the query chosen by operationName by groups of its root
+// fields, the group of the field is chosen by its name. Aliases are kept,
+// root selections other than fields are in the group of the empty name.
+// Root fields of mutations run in order, so the whole mutation is in the
+// group of its first field. Groups are ordered by their first field
+func GroupSplit(request []byte, group func(field string) string) ([]Group, error) {
+	op, err := parseOperation(request)
+	if err != nil {
+		return nil, err
+	}
+	if op.def == nil {
+		return nil, ErrNotFound
+	}
+
+	selections := op.selections()
+	if op.def.Operation != "query" {
+		name := group("")
+		keys := make([]string, 0, len(selections))
+		for _, selection := range selections {
+			if field, ok := selection.(*ast.Field); ok {
+				if len(keys) == 0 {
+					name = group(field.Name.Value)
+				}
+				keys = append(keys, responseKey(field))
+			}
+		}
+		return []Group{{Name: name, Keys: keys, Doc: request}}, nil
+	}
+
+	order := make([]string, 0)
+	groups := make(map[string]*Group)
+	fields := make(map[string][]ast.Selection)
+	for _, selection := range selections {
+		name, key := group(""), ""
+		if field, ok := selection.(*ast.Field); ok {
+			name, key = group(field.Name.Value), responseKey(field)
+		}
+		g, ok := groups[name]
+		if !ok {
+			g = &Group{Name: name}
+			groups[name] = g
+			order = append(order, name)
+		}
+		if key != "" {
+			g.Keys = append(g.Keys, key)
+		}
+		fields[name] = append(fields[name], selection)
+	}
+	if len(order) == 1 {
+		groups[order[0]].Doc = request
+		return []Group{*groups[order[0]]}, nil
+	}
+
+	result := make([]Group, 0, len(order))
+	for _, name := range order {
+		g := groups[name]
+		g.Doc = op.build(fields[name])
+		result = append(result, *g)
+	}
+	return result, nil
+}
+
+// ResponseKeys returns root response keys of the operation chosen by
+// operationName in the order of its selections, fields of root fragments
+// are in place of the fragment
+func ResponseKeys(request []byte) ([]string, error) {
+	op, err := parseOperation(request)
+	if err != nil {
+		return nil, err
+	}
+	if op.def == nil {
+		return nil, ErrNotFound
+	}
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	var walk func(selections []ast.Selection, visited map[string]bool)
+	walk = func(selections []ast.Selection, visited map[string]bool) {
+		for _, selection := range selections {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				if key := responseKey(selection); !seen[key] {
+					seen[key] = true
+					keys = append(keys, key)
+				}
+			case *ast.InlineFragment:
+				walk(selection.SelectionSet.Selections, visited)
+			case *ast.FragmentSpread:
+				name := selection.Name.Value
+				if fragment, ok := op.fragments[name]; ok && !visited[name] {
+					visited[name] = true
+					walk(fragment.SelectionSet.Selections, visited)
+					delete(visited, name)
+				}
+			}
+		}
+	}
+	walk(op.def.SelectionSet.Selections, make(map[string]bool))
+	return keys, nil
+}
+
+// operation chosen by operationName of the request, def is nil when it's
+// ambiguous or unknown
+type operation struct {
+	req       *fastjson.Value
+	arena     *fastjson.Arena
+	doc       *ast.Document
+	fragments map[string]*ast.FragmentDefinition
+	def       *ast.OperationDefinition
+}
+
+func parseOperation(request []byte) (*operation, error) {
+	req, err := fastjson.ParseBytes(request)
+	if err != nil {
+		return nil, err
+	}
 
 	doc, err := parser.Parse(parser.ParseParams{
 		Source: source.NewSource(&source.Source{
@@ -31,53 +206,162 @@ func QuerySplit(request []byte, names []string) ([]byte, map[string][]byte, erro
 		}),
 	})
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	index := make(map[string]bool)
-	for i := range names {
-		index[names[i]] = true
+	op := &operation{
+		req:       req,
+		arena:     new(fastjson.Arena),
+		doc:       doc,
+		fragments: make(map[string]*ast.FragmentDefinition),
+	}
+	operationName := string(req.GetStringBytes("operationName"))
+	operations := 0
+	for i := range doc.Definitions {
+		switch def := doc.Definitions[i].(type) {
+		case *ast.FragmentDefinition:
+			op.fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			operations++
+			if operationName == "" || def.Name != nil && def.Name.Value == operationName {
+				op.def = def
+			}
+		}
 	}
+	if operationName == "" && operations > 1 {
+		op.def = nil
+	}
+	return op, nil
+}
 
-	docs := make(map[string][]byte)
-	for i := 0; i < len(doc.Definitions); i++ {
-		if doc.Definitions[i].GetKind() != "OperationDefinition" {
-			continue
+// selections of the operation with root level fragments inlined
+func (op *operation) selections() []ast.Selection {
+	return inlineFragments(op.def.SelectionSet.Selections, op.fragments, make(map[string]bool))
+}
+
+// build the request of the operation with the given root selections, it
+// carries only the fragments and variables they use
+func (op *operation) build(selections []ast.Selection) []byte {
+	usage := newUsage(op.fragments)
+	usage.directives(op.def.Directives)
+	usage.selections(selections)
+
+	opd := *op.def
+	opd.SelectionSet = ast.NewSelectionSet(&ast.SelectionSet{Selections: selections})
+	opd.VariableDefinitions = make([]*ast.VariableDefinition, 0, len(op.def.VariableDefinitions))
+	for _, def := range op.def.VariableDefinitions {
+		if usage.variables[def.Variable.Name.Value] {
+			opd.VariableDefinitions = append(opd.VariableDefinitions, def)
+		}
+	}
+	definitions := []ast.Node{&opd}
+	for i := range op.doc.Definitions {
+		if def, ok := op.doc.Definitions[i].(*ast.FragmentDefinition); ok && usage.fragments[def.Name.Value] {
+			definitions = append(definitions, def)
 		}
-		opDef := doc.Definitions[i].(*ast.OperationDefinition)
-		for j := 0; j < len(opDef.SelectionSet.Selections); j++ {
-			field, ok := opDef.SelectionSet.Selections[j].(*ast.Field)
-			if !ok || !index[field.Name.Value] {
+	}
+
+	obj := op.arena.NewObject()
+	obj.Set("query", op.arena.NewString(printEscaped(ast.NewDocument(&ast.Document{Definitions: definitions}))))
+	obj.Set("variables", op.req.Get("variables"))
+	obj.Set("operationName", op.req.Get("operationName"))
+	return []byte(obj.String())
+}
+
+func responseKey(field *ast.Field) string {
+	if field.Alias != nil {
+		return field.Alias.Value
+	}
+	return field.Name.Value
+}
+
+// inlineFragments replace root level fragments by their selections, fragments
+// with directives are kept as is
+func inlineFragments(selections []ast.Selection, fragments map[string]*ast.FragmentDefinition, visited map[string]bool) []ast.Selection {
+	inlined := make([]ast.Selection, 0, len(selections))
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *ast.InlineFragment:
+			if len(selection.Directives) == 0 {
+				inlined = append(inlined, inlineFragments(selection.SelectionSet.Selections, fragments, visited)...)
+				continue
+			}
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			if fragment, ok := fragments[name]; ok && !visited[name] && len(selection.Directives) == 0 && len(fragment.Directives) == 0 {
+				visited[name] = true
+				inlined = append(inlined, inlineFragments(fragment.SelectionSet.Selections, fragments, visited)...)
+				delete(visited, name)
 				continue
 			}
-			opd := new(ast.OperationDefinition)
-			copier.Copy(&opd, opDef)
-			opd.SelectionSet.Selections = []ast.Selection{field}
-
-			obj := arena.NewObject()
-			obj.Set("query", arena.NewString(printer.Print(opd).(string)))
-			obj.Set("variables", req.Get("variables"))
-			obj.Set("operationName", req.Get("operationName"))
-			docs[field.Name.Value] = []byte(obj.String())
-			opDef.SelectionSet.Selections = append(opDef.SelectionSet.Selections[:j], opDef.SelectionSet.Selections[j+1:]...)
-			j--
 		}
-		if len(opDef.SelectionSet.Selections) == 0 {
-			doc.Definitions = append(doc.Definitions[:i], doc.Definitions[i+1:]...)
-			i--
+		inlined = append(inlined, selection)
+	}
+	return inlined
+}
+
+// usage collects fragments and variables used by selections
+type usage struct {
+	definitions map[string]*ast.FragmentDefinition
+	fragments   map[string]bool
+	variables   map[string]bool
+}
+
+func newUsage(definitions map[string]*ast.FragmentDefinition) *usage {
+	return &usage{
+		definitions: definitions,
+		fragments:   make(map[string]bool),
+		variables:   make(map[string]bool),
+	}
+}
+
+func (u *usage) selections(selections []ast.Selection) {
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			for _, arg := range selection.Arguments {
+				u.value(arg.Value)
+			}
+			u.directives(selection.Directives)
+			if selection.SelectionSet != nil {
+				u.selections(selection.SelectionSet.Selections)
+			}
+		case *ast.InlineFragment:
+			u.directives(selection.Directives)
+			u.selections(selection.SelectionSet.Selections)
+		case *ast.FragmentSpread:
+			u.directives(selection.Directives)
+			name := selection.Name.Value
+			if fragment, ok := u.definitions[name]; ok && !u.fragments[name] {
+				u.fragments[name] = true
+				u.directives(fragment.Directives)
+				u.selections(fragment.SelectionSet.Selections)
+			}
 		}
 	}
+}
 
-	var defDoc []byte
-	if len(doc.Definitions) > 0 {
-		obj := arena.NewObject()
-		obj.Set("query", arena.NewString(printer.Print(doc).(string)))
-		obj.Set("variables", req.Get("variables"))
-		obj.Set("operationName", req.Get("operationName"))
-		defDoc = []byte(obj.String())
+func (u *usage) directives(directives []*ast.Directive) {
+	for _, directive := range directives {
+		for _, arg := range directive.Arguments {
+			u.value(arg.Value)
+		}
 	}
+}
 
-	return defDoc, docs, nil
+func (u *usage) value(value ast.Value) {
+	switch value := value.(type) {
+	case *ast.Variable:
+		u.variables[value.Name.Value] = true
+	case *ast.ListValue:
+		for _, item := range value.Values {
+			u.value(item)
+		}
+	case *ast.ObjectValue:
+		for _, field := range value.Fields {
+			u.value(field.Value)
+		}
+	}
 }
 
 // QueryParams get graphql query names and params
@@ -162,3 +446,96 @@ func GetParam(request []byte, queryName string, argName string) (*ast.Argument,
 	}
 	return nil, ErrNotFound
 }
+
+// StripDirective remove the directive from operations and their root fields,
+// reports whether the directive was found. It's used for gap-filler own
+// directives which postgraphile doesn't know about
+func StripDirective(request []byte, name string) ([]byte, bool, error) {
+	req, err := fastjson.ParseBytes(request)
+	if err != nil {
+		return nil, false, err
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: req.GetStringBytes("query"),
+		}),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	strip := func(directives []*ast.Directive) ([]*ast.Directive, bool) {
+		found := false
+		kept := make([]*ast.Directive, 0, len(directives))
+		for _, directive := range directives {
+			if directive.Name.Value == name {
+				found = true
+				continue
+			}
+			kept = append(kept, directive)
+		}
+		return kept, found
+	}
+
+	found := false
+	for i := range doc.Definitions {
+		opDef, ok := doc.Definitions[i].(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		var stripped bool
+		opDef.Directives, stripped = strip(opDef.Directives)
+		found = found || stripped
+		for _, selection := range opDef.SelectionSet.Selections {
+			field, ok := selection.(*ast.Field)
+			if !ok {
+				continue
+			}
+			field.Directives, stripped = strip(field.Directives)
+			found = found || stripped
+		}
+	}
+	if !found {
+		return request, false, nil
+	}
+
+	arena := new(fastjson.Arena)
+	req.Set("query", arena.NewString(printEscaped(doc)))
+	return []byte(req.String()), true, nil
+}
+
+// OperationType returns the type of the operation chosen by operationName:
+// query, mutation or subscription
+func OperationType(request []byte) (string, error) {
+	req, err := fastjson.ParseBytes(request)
+	if err != nil {
+		return "", err
+	}
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: req.GetStringBytes("query"),
+		}),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	operationName := string(req.GetStringBytes("operationName"))
+	var opDef *ast.OperationDefinition
+	for i := range doc.Definitions {
+		def, ok := doc.Definitions[i].(*ast.OperationDefinition)
+		if !ok || operationName != "" && (def.Name == nil || def.Name.Value != operationName) {
+			continue
+		}
+		if opDef != nil {
+			// ambiguous without operationName
+			return "", ErrNotFound
+		}
+		opDef = def
+	}
+	if opDef == nil {
+		return "", ErrNotFound
+	}
+	return opDef.Operation, nil
+}
@@ -0,0 +1,201 @@
+package qlparser
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/valyala/fastjson"
+)
+
+func TestStripDirective(t *testing.T) {
+	requests := []string{
+		`{"query":"query MyQuery @async { ethHeaderCidByBlockNumber(n: \"1\") { nodes { cid } } }"}`,
+		`{"query":"query MyQuery { ethHeaderCidByBlockNumber(n: \"1\") @async { nodes { cid } } }"}`,
+	}
+	for i, request := range requests {
+		body, found, err := StripDirective([]byte(request), "async")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			t.Errorf("[%d] Want: found, Got: not found", i)
+		}
+		if strings.Contains(string(body), "@async") {
+			t.Errorf("[%d] Want: no directive, Got: %s", i, body)
+		}
+	}
+
+	request := `{"query":"query MyQuery { ethHeaderCidByBlockNumber(n: \"1\") @include(if: true) { nodes { cid } } }"}`
+	body, found, err := StripDirective([]byte(request), "async")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found || string(body) != request {
+		t.Errorf("Want: %s, Got: %s", request, body)
+	}
+}
+
+func TestQuerySplitAliases(t *testing.T) {
+	request := `{"query":"query MyQuery { h1: ethHeaderCidByBlockNumber(n: \"1\") { nodes { cid } } h2: ethHeaderCidByBlockNumber(n: \"2\") { nodes { cid } } }","variables":null,"operationName":"MyQuery"}`
+	ddoc, queries, err := QuerySplit([]byte(request), []string{"ethHeaderCidByBlockNumber"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ddoc != nil {
+		t.Errorf("Want: no default document, Got: %s", ddoc)
+	}
+	if len(queries) != 2 {
+		t.Fatalf("Want: 2 queries, Got: %d", len(queries))
+	}
+	for key, n := range map[string]string{"h1": "1", "h2": "2"} {
+		query := queries[key]
+		if query.Name != "ethHeaderCidByBlockNumber" {
+			t.Errorf("[%s] Want: ethHeaderCidByBlockNumber, Got: %s", key, query.Name)
+		}
+		args, err := RequestParams(query.Doc, query.Name)
+		if err != nil {
+			t.Fatalf("[%s] %v", key, err)
+		}
+		if got := PrintArgs(args); got != `n: "`+n+`"` {
+			t.Errorf("[%s] Want: n: %q, Got: %s", key, n, got)
+		}
+		if strings.Contains(string(query.Doc), key+":") {
+			t.Errorf("[%s] Want: no alias, Got: %s", key, query.Doc)
+		}
+	}
+}
+
+func TestQuerySplitFragments(t *testing.T) {
+	request := `{
+		"query": "query Other { ethHeaderCidByBlockNumber(n: \"9\") { nodes { cid } } } query MyQuery($n: BigFloat!, $id: String!) { ...Headers ... on Query { receipts: receiptCidsByTxHash(txHash: \"0x1\") { nodes { cid } } } node(nodeId: $id) { id } } fragment Headers on Query { ethHeaderCidByBlockNumber(n: $n) { nodes { ...Header } } } fragment Header on EthHeaderCid { cid blockNumber }",
+		"variables": {"n": "1", "id": "abc"},
+		"operationName": "MyQuery"
+	}`
+	ddoc, queries, err := QuerySplit([]byte(request), []string{"ethHeaderCidByBlockNumber", "receiptCidsByTxHash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 {
+		t.Fatalf("Want: 2 queries, Got: %d", len(queries))
+	}
+
+	header := string(queries["ethHeaderCidByBlockNumber"].Doc)
+	for _, want := range []string{"fragment Header on EthHeaderCid", "$n: BigFloat!", `"operationName":"MyQuery"`} {
+		if !strings.Contains(header, want) {
+			t.Errorf("Want: %s in %s", want, header)
+		}
+	}
+	for _, unwanted := range []string{"fragment Headers", "$id", "Other", `\"9\"`} {
+		if strings.Contains(header, unwanted) {
+			t.Errorf("Want: no %s in %s", unwanted, header)
+		}
+	}
+	if query := queries["receipts"]; query.Name != "receiptCidsByTxHash" || strings.Contains(string(query.Doc), "fragment") {
+		t.Errorf("Want: receiptCidsByTxHash without fragments, Got: %s %s", query.Name, query.Doc)
+	}
+
+	rest := string(ddoc)
+	if !strings.Contains(rest, "node(nodeId: $id)") || !strings.Contains(rest, "$id: String!") {
+		t.Errorf("Want: node query, Got: %s", rest)
+	}
+	for _, unwanted := range []string{"ethHeaderCidByBlockNumber", "fragment", "$n"} {
+		if strings.Contains(rest, unwanted) {
+			t.Errorf("Want: no %s in %s", unwanted, rest)
+		}
+	}
+}
+
+func TestOperationType(t *testing.T) {
+	for _, tc := range []struct {
+		request string
+		want    string
+		err     error
+	}{
+		{`{"query":"{ allEthHeaderCids { totalCount } }"}`, "query", nil},
+		{`{"query":"query A { a } mutation B { b }","operationName":"B"}`, "mutation", nil},
+		{`{"query":"query A { a } mutation B { b }"}`, "", ErrNotFound},
+		{`{"query":"query A { a }","operationName":"C"}`, "", ErrNotFound},
+	} {
+		got, err := OperationType([]byte(tc.request))
+		if got != tc.want || !errors.Is(err, tc.err) {
+			t.Errorf("%s Want: %q %v, Got: %q %v", tc.request, tc.want, tc.err, got, err)
+		}
+	}
+}
+
+func TestGroupSplit(t *testing.T) {
+	route := func(field string) string {
+		if strings.HasPrefix(field, "graph") {
+			return "tracing"
+		}
+		return "default"
+	}
+
+	request := `{"query":"query MyQuery($id: String!, $tx: String!) { node(nodeId: $id) { id } calls: graphCallByTxHash(txHash: $tx) { nodes { id } } allEthHeaderCids { totalCount } }","variables":{"id":"abc","tx":"0x1"},"operationName":"MyQuery"}`
+	groups, err := GroupSplit([]byte(request), route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Name != "default" || groups[1].Name != "tracing" {
+		t.Fatalf("Want: default and tracing groups, Got: %+v", groups)
+	}
+	if keys := strings.Join(groups[0].Keys, ","); keys != "node,allEthHeaderCids" {
+		t.Errorf("Want: node,allEthHeaderCids, Got: %s", keys)
+	}
+	if keys := strings.Join(groups[1].Keys, ","); keys != "calls" {
+		t.Errorf("Want: calls, Got: %s", keys)
+	}
+	tracing := string(groups[1].Doc)
+	for _, want := range []string{"calls: graphCallByTxHash", "$tx: String!"} {
+		if !strings.Contains(tracing, want) {
+			t.Errorf("Want: %s in %s", want, tracing)
+		}
+	}
+	if strings.Contains(tracing, "$id") || strings.Contains(tracing, "node(") {
+		t.Errorf("Want: no node query in %s", tracing)
+	}
+
+	// single group request is kept as is
+	request = `{"query":"{ node(nodeId: \"abc\") { id } }"}`
+	if groups, err := GroupSplit([]byte(request), route); err != nil || len(groups) != 1 || string(groups[0].Doc) != request {
+		t.Errorf("Want: the request, Got: %+v %v", groups, err)
+	}
+
+	// mutation isn't split, it's routed by the first field
+	request = `{"query":"mutation { graphCreate { id } createNode { id } }"}`
+	if groups, err := GroupSplit([]byte(request), route); err != nil || len(groups) != 1 || groups[0].Name != "tracing" {
+		t.Errorf("Want: tracing mutation, Got: %+v %v", groups, err)
+	}
+}
+
+func TestQuerySplitEscaped(t *testing.T) {
+	request := `{"query":"{ ethHeaderCidByBlockNumber(n: \"1\") { nodes { cid } } node(id: \"a\\\", x: \\\"b\") { id } }"}`
+	for i := 0; i < 2; i++ {
+		ddoc, _, err := QuerySplit([]byte(request), []string{"ethHeaderCidByBlockNumber"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := fastjson.MustParseBytes(ddoc).GetStringBytes("query")
+		args, err := GetParams(query, "node")
+		if err != nil {
+			t.Fatalf("[%d] %v: %s", i, err, query)
+		}
+		if len(args) != 1 || args[0].Value.(*ast.StringValue).Value != `a", x: "b` {
+			t.Errorf("[%d] Want: single id argument, Got: %s", i, query)
+		}
+	}
+}
+
+func TestResponseKeys(t *testing.T) {
+	request := `{"query":"query Other { a } query MyQuery { z: ethHeaderCidByBlockNumber(n: \"1\") { cid } ...Root ... on Query { b } z: ethHeaderCidByBlockNumber(n: \"1\") { cid } } fragment Root on Query { node(nodeId: \"x\") { id } a: allEthHeaderCids { totalCount } }","operationName":"MyQuery"}`
+	keys, err := ResponseKeys([]byte(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"z", "node", "a", "b"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Want: %v, Got: %v", want, keys)
+	}
+}
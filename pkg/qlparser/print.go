@@ -0,0 +1,37 @@
+package qlparser
+
+import (
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/kinds"
+	"github.com/graphql-go/graphql/language/printer"
+	"github.com/graphql-go/graphql/language/visitor"
+)
+
+// printEscaped print the node with strings escaped, the printer of graphql-go
+// writes them as is, so a quote in the value ends the literal. Strings are
+// escaped in place and restored after printing, the node must not be shared
+func printEscaped(node ast.Node) string {
+	values := make([]*ast.StringValue, 0)
+	visitor.Visit(node, &visitor.VisitorOptions{
+		KindFuncMap: map[string]visitor.NamedVisitFuncs{
+			kinds.StringValue: {Kind: func(p visitor.VisitFuncParams) (string, interface{}) {
+				if value, ok := p.Node.(*ast.StringValue); ok {
+					values = append(values, value)
+				}
+				return visitor.ActionNoChange, nil
+			}},
+		},
+	}, nil)
+
+	raw := make([]string, len(values))
+	for i, value := range values {
+		raw[i] = value.Value
+		value.Value = escapeString(value.Value)
+	}
+	defer func() {
+		for i, value := range values {
+			value.Value = raw[i]
+		}
+	}()
+	return printer.Print(node).(string)
+}
@@ -0,0 +1,150 @@
+package qlparser
+
+import (
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/valyala/fastjson"
+)
+
+// RequestParams get params of the query from the graphql request with
+// variables resolved against the request variables
+func RequestParams(request []byte, queryName string) ([]*ast.Argument, error) {
+	req, err := fastjson.ParseBytes(request)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: req.GetStringBytes("query"),
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	variables := req.Get("variables")
+	if variables != nil && variables.Type() == fastjson.TypeString {
+		// some clients send variables as a json string
+		if variables, err = fastjson.ParseBytes(variables.GetStringBytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	operationName := string(req.GetStringBytes("operationName"))
+	for i := range doc.Definitions {
+		op, ok := doc.Definitions[i].(*ast.OperationDefinition)
+		if !ok || op.Operation != "query" {
+			continue
+		}
+		if operationName != "" && (op.Name == nil || op.Name.Value != operationName) {
+			continue
+		}
+		for j := range op.SelectionSet.Selections {
+			field, ok := op.SelectionSet.Selections[j].(*ast.Field)
+			if !ok || field.Name.Value != queryName {
+				continue
+			}
+			return ResolveArgs(field.Arguments, op.VariableDefinitions, variables), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ResolveArgs replace variable references with literals built from the
+// request variables or default values of the variable definitions. Arguments
+// of missing or null variables are omitted as if they weren't passed
+func ResolveArgs(args []*ast.Argument, defs []*ast.VariableDefinition, variables *fastjson.Value) []*ast.Argument {
+	defaults := make(map[string]ast.Value)
+	for _, def := range defs {
+		if def.DefaultValue != nil {
+			defaults[def.Variable.Name.Value] = def.DefaultValue
+		}
+	}
+	resolve := func(name string) ast.Value {
+		if variables != nil {
+			if value := variables.Get(name); value != nil {
+				return jsonValue(value)
+			}
+		}
+		return defaults[name]
+	}
+
+	resolved := make([]*ast.Argument, 0, len(args))
+	for _, arg := range args {
+		value := resolveValue(arg.Value, resolve)
+		if value == nil {
+			continue
+		}
+		resolved = append(resolved, ast.NewArgument(&ast.Argument{
+			Name:  arg.Name,
+			Value: value,
+		}))
+	}
+	return resolved
+}
+
+func resolveValue(value ast.Value, resolve func(name string) ast.Value) ast.Value {
+	switch value := value.(type) {
+	case *ast.Variable:
+		return resolve(value.Name.Value)
+	case *ast.ListValue:
+		values := make([]ast.Value, 0, len(value.Values))
+		for _, item := range value.Values {
+			if item = resolveValue(item, resolve); item != nil {
+				values = append(values, item)
+			}
+		}
+		return ast.NewListValue(&ast.ListValue{Values: values})
+	case *ast.ObjectValue:
+		fields := make([]*ast.ObjectField, 0, len(value.Fields))
+		for _, field := range value.Fields {
+			if item := resolveValue(field.Value, resolve); item != nil {
+				fields = append(fields, ast.NewObjectField(&ast.ObjectField{
+					Name:  field.Name,
+					Value: item,
+				}))
+			}
+		}
+		return ast.NewObjectValue(&ast.ObjectValue{Fields: fields})
+	}
+	return value
+}
+
+// jsonValue build graphql literal from the json value, null gives nil
+func jsonValue(value *fastjson.Value) ast.Value {
+	switch value.Type() {
+	case fastjson.TypeString:
+		return ast.NewStringValue(&ast.StringValue{Value: string(value.GetStringBytes())})
+	case fastjson.TypeNumber:
+		raw := value.String()
+		if strings.ContainsAny(raw, ".eE") {
+			return ast.NewFloatValue(&ast.FloatValue{Value: raw})
+		}
+		return ast.NewIntValue(&ast.IntValue{Value: raw})
+	case fastjson.TypeTrue, fastjson.TypeFalse:
+		return ast.NewBooleanValue(&ast.BooleanValue{Value: value.GetBool()})
+	case fastjson.TypeArray:
+		values := make([]ast.Value, 0)
+		for _, item := range value.GetArray() {
+			if item := jsonValue(item); item != nil {
+				values = append(values, item)
+			}
+		}
+		return ast.NewListValue(&ast.ListValue{Values: values})
+	case fastjson.TypeObject:
+		fields := make([]*ast.ObjectField, 0)
+		value.GetObject().Visit(func(key []byte, item *fastjson.Value) {
+			if item := jsonValue(item); item != nil {
+				fields = append(fields, ast.NewObjectField(&ast.ObjectField{
+					Name:  ast.NewName(&ast.Name{Value: string(key)}),
+					Value: item,
+				}))
+			}
+		})
+		return ast.NewObjectValue(&ast.ObjectValue{Fields: fields})
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package qlparser
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+func TestRequestParams(t *testing.T) {
+	requests := []string{
+		`{"query":"query MyQuery($n: BigFloat!) { ethHeaderCidByBlockNumber(n: $n) { nodes { cid } } }","variables":{"n":"123"},"operationName":"MyQuery"}`,
+		`{"query":"query MyQuery($n: BigFloat! = \"123\") { ethHeaderCidByBlockNumber(n: $n) { nodes { cid } } }","variables":null}`,
+		`{"query":"query MyQuery($n: BigFloat!) { ethHeaderCidByBlockNumber(n: $n) { nodes { cid } } }","variables":"{\"n\":\"123\"}"}`,
+		`{"query":"query MyQuery { ethHeaderCidByBlockNumber(n: \"123\") { nodes { cid } } }"}`,
+	}
+	want := `n: "123"`
+	for i, request := range requests {
+		args, err := RequestParams([]byte(request), "ethHeaderCidByBlockNumber")
+		if err != nil {
+			t.Fatalf("[%d] %v", i, err)
+		}
+		if got := PrintArgs(args); got != want {
+			t.Errorf("[%d] Want: %s, Got: %s", i, want, got)
+		}
+	}
+}
+
+func TestRequestParamsNested(t *testing.T) {
+	request := `{
+		"query": "query MyQuery($from: BigFloat, $to: BigFloat = \"20\", $first: Int, $skip: Int) { allEthHeaderCids(first: $first, offset: $skip, filter: {blockNumber: {greaterThan: $from, lessThan: $to}}) { nodes { cid } } }",
+		"variables": {"from": "10", "first": 10, "skip": null}
+	}`
+	args, err := RequestParams([]byte(request), "allEthHeaderCids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `filter: {blockNumber: {greaterThan: "10", lessThan: "20"}}, first: 10`
+	if got := PrintArgs(args); got != want {
+		t.Errorf("Want: %s, Got: %s", want, got)
+	}
+}
+
+func TestRequestParamsEscaped(t *testing.T) {
+	requests := []string{
+		`{"query":"query MyQuery($n: BigFloat!) { ethHeaderCidByBlockNumber(n: $n) { nodes { cid } } }","variables":{"n":"1\", m: \"2"}}`,
+		`{"query":"query MyQuery { ethHeaderCidByBlockNumber(n: \"1\\\", m: \\\"2\") { nodes { cid } } }"}`,
+	}
+	want := `n: "1\", m: \"2"`
+	for i, request := range requests {
+		args, err := RequestParams([]byte(request), "ethHeaderCidByBlockNumber")
+		if err != nil {
+			t.Fatalf("[%d] %v", i, err)
+		}
+		printed := PrintArgs(args)
+		if printed != want {
+			t.Errorf("[%d] Want: %s, Got: %s", i, want, printed)
+		}
+		parsed, err := ParseArgs(printed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parsed) != 1 || parsed[0].Value.(*ast.StringValue).Value != `1", m: "2` {
+			t.Errorf("[%d] Want: single n argument, Got: %s", i, printed)
+		}
+	}
+}
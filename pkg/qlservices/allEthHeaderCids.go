@@ -0,0 +1,236 @@
+package qlservices
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fastjson"
+	"github.com/vulcanize/gap-filler/pkg/rpcpool"
+)
+
+// maxBlockRange is the biggest range allEthHeaderCids is allowed to fill
+const maxBlockRange = 1000
+
+type AllEthHeaderCidsService struct {
+	pool *rpcpool.Pool
+}
+
+func NewAllEthHeaderCidsService(pool *rpcpool.Pool) *AllEthHeaderCidsService {
+	return &AllEthHeaderCidsService{pool: pool}
+}
+
+func (srv *AllEthHeaderCidsService) Name() string {
+	return "allEthHeaderCids"
+}
+
+// blockRange get inclusive block range from
+// `condition: {blockNumber: "X"}` or
+// `filter: {blockNumber: {greaterThanOrEqualTo: "X", lessThanOrEqualTo: "Y"}}`
+func (srv *AllEthHeaderCidsService) blockRange(args []*ast.Argument) (uint64, uint64, error) {
+	for i := range args {
+		switch args[i].Name.Value {
+		case "condition":
+			value := objectField(args[i].Value, "blockNumber")
+			if value == nil {
+				continue
+			}
+			n, err := blockNumber(value)
+			if err != nil {
+				return 0, 0, err
+			}
+			return n, n, nil
+		case "filter":
+			value := objectField(args[i].Value, "blockNumber")
+			if value == nil {
+				continue
+			}
+			return filterRange(value)
+		}
+	}
+	return 0, 0, ErrNoArgs
+}
+
+func (srv *AllEthHeaderCidsService) Validate(args []*ast.Argument) error {
+	_, _, err := srv.blockRange(args)
+	return err
+}
+
+func (srv *AllEthHeaderCidsService) IsEmpty(data []byte) (bool, error) {
+	return isEmptyResult(data, srv.Name())
+}
+
+func (srv *AllEthHeaderCidsService) Do(ctx context.Context, args []*ast.Argument) error {
+	from, to, err := srv.blockRange(args)
+	if err != nil {
+		return err
+	}
+	for n := from; n <= to; n++ {
+		if err := srv.DoAt(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Missing returns block numbers of the requested range which are absent in
+// the response. When nodes don't select blockNumber only the count of nodes
+// can be checked, so either all or none of the heights are reported
+func (srv *AllEthHeaderCidsService) Missing(args []*ast.Argument, data []byte) ([]uint64, error) {
+	from, to, err := srv.blockRange(args)
+	if err != nil {
+		return nil, err
+	}
+	json, err := fastjson.ParseBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*fastjson.Value
+	result := json.Get("data", srv.Name())
+	if nodes = result.GetArray("nodes"); nodes == nil {
+		for _, edge := range result.GetArray("edges") {
+			nodes = append(nodes, edge.Get("node"))
+		}
+	}
+
+	seen := make(map[uint64]bool, len(nodes))
+	for _, node := range nodes {
+		n, ok := nodeBlockNumber(node)
+		if !ok {
+			seen = nil
+			break
+		}
+		seen[n] = true
+	}
+
+	missing := make([]uint64, 0)
+	for n := from; n <= to; n++ {
+		if seen == nil && uint64(len(nodes)) > to-from {
+			break
+		}
+		if !seen[n] {
+			missing = append(missing, n)
+		}
+	}
+	return missing, nil
+}
+
+// DoAt write state diff for the single block of the range
+func (srv *AllEthHeaderCidsService) DoAt(ctx context.Context, n uint64) error {
+	params := stateDiffParams()
+	log := logrus.WithFields(logrus.Fields{
+		"blockNum": n,
+		"params":   params,
+	})
+	log.Debug("do request to Geth")
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	if err := srv.pool.CheckBlock(ctx, n); err != nil {
+		return err
+	}
+	var data json.RawMessage
+
+	return srv.pool.CallContext(ctx, log, &data, stateDiffMethod, n, params)
+}
+
+// nodeBlockNumber get blockNumber of the connection node, BigInt or Int
+func nodeBlockNumber(node *fastjson.Value) (uint64, bool) {
+	value := node.Get("blockNumber")
+	if value == nil {
+		return 0, false
+	}
+	switch value.Type() {
+	case fastjson.TypeString:
+		n, ok := new(big.Int).SetString(string(value.GetStringBytes()), 10)
+		if !ok || !n.IsUint64() {
+			return 0, false
+		}
+		return n.Uint64(), true
+	case fastjson.TypeNumber:
+		n, err := value.Uint64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// objectField get field value of graphql input object
+func objectField(value ast.Value, name string) ast.Value {
+	obj, ok := value.(*ast.ObjectValue)
+	if !ok {
+		return nil
+	}
+	for _, field := range obj.Fields {
+		if field.Name.Value == name {
+			return field.Value
+		}
+	}
+	return nil
+}
+
+// blockNumber parse decimal block number, postgraphile BigInt is a string
+func blockNumber(value ast.Value) (uint64, error) {
+	str, ok := value.GetValue().(string)
+	if !ok {
+		return 0, ErrBadType
+	}
+	n, ok := new(big.Int).SetString(str, 10)
+	if !ok || !n.IsUint64() {
+		return 0, ErrBadType
+	}
+	return n.Uint64(), nil
+}
+
+// rangeOperators of postgraphile-plugin-connection-filter bounding the range
+var rangeOperators = map[string]bool{
+	"equalTo":              true,
+	"greaterThanOrEqualTo": true,
+	"greaterThan":          true,
+	"lessThanOrEqualTo":    true,
+	"lessThan":             true,
+}
+
+// filterRange get inclusive range from postgraphile-plugin-connection-filter
+// operators, other operators only narrow the range, so they are ignored
+func filterRange(value ast.Value) (uint64, uint64, error) {
+	obj, ok := value.(*ast.ObjectValue)
+	if !ok {
+		return 0, 0, ErrBadType
+	}
+	var (
+		from, to       uint64
+		hasFrom, hasTo bool
+	)
+	for _, field := range obj.Fields {
+		if !rangeOperators[field.Name.Value] {
+			continue
+		}
+		n, err := blockNumber(field.Value)
+		if err != nil {
+			return 0, 0, err
+		}
+		switch field.Name.Value {
+		case "equalTo":
+			from, to, hasFrom, hasTo = n, n, true, true
+		case "greaterThanOrEqualTo":
+			from, hasFrom = n, true
+		case "greaterThan":
+			from, hasFrom = n+1, true
+		case "lessThanOrEqualTo":
+			to, hasTo = n, true
+		case "lessThan":
+			if n == 0 {
+				return 0, 0, ErrBadRange
+			}
+			to, hasTo = n-1, true
+		}
+	}
+	if !hasFrom || !hasTo || from > to || to-from >= maxBlockRange {
+		return 0, 0, ErrBadRange
+	}
+	return from, to, nil
+}
@@ -0,0 +1,93 @@
+package qlservices
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+func parseArgs(t *testing.T, query string) []*ast.Argument {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(query)}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := doc.Definitions[0].(*ast.OperationDefinition)
+	return op.SelectionSet.Selections[0].(*ast.Field).Arguments
+}
+
+func TestAllEthHeaderCidsBlockRange(t *testing.T) {
+	type testCase struct {
+		Query    string
+		From, To uint64
+		Err      error
+	}
+	cases := []testCase{
+		{`{ allEthHeaderCids(condition: {blockNumber: "10"}) { nodes { blockHash } } }`, 10, 10, nil},
+		{`{ allEthHeaderCids(filter: {blockNumber: {greaterThanOrEqualTo: "10", lessThanOrEqualTo: "20"}}) { nodes { blockHash } } }`, 10, 20, nil},
+		{`{ allEthHeaderCids(filter: {blockNumber: {greaterThan: "10", lessThan: "20"}}) { nodes { blockHash } } }`, 11, 19, nil},
+		{`{ allEthHeaderCids(filter: {blockNumber: {greaterThan: "10", lessThan: "20", in: ["12", "15"], isNull: false}}) { nodes { blockHash } } }`, 11, 19, nil},
+		{`{ allEthHeaderCids(filter: {blockNumber: {in: ["12", "15"]}}) { nodes { blockHash } } }`, 0, 0, ErrBadRange},
+		{`{ allEthHeaderCids(filter: {blockNumber: {greaterThan: "10"}}) { nodes { blockHash } } }`, 0, 0, ErrBadRange},
+		{`{ allEthHeaderCids(filter: {blockNumber: {greaterThan: "20", lessThan: "10"}}) { nodes { blockHash } } }`, 0, 0, ErrBadRange},
+		{`{ allEthHeaderCids(filter: {blockNumber: {greaterThan: "0", lessThan: "100000"}}) { nodes { blockHash } } }`, 0, 0, ErrBadRange},
+		{`{ allEthHeaderCids(first: 10) { nodes { blockHash } } }`, 0, 0, ErrNoArgs},
+	}
+	srv := NewAllEthHeaderCidsService(nil)
+	for i, c := range cases {
+		from, to, err := srv.blockRange(parseArgs(t, c.Query))
+		if !errors.Is(err, c.Err) {
+			t.Errorf("[%d] Want: %v, Got: %v", i, c.Err, err)
+		}
+		if from != c.From || to != c.To {
+			t.Errorf("[%d] Want: %d-%d, Got: %d-%d", i, c.From, c.To, from, to)
+		}
+	}
+}
+
+func TestIsEmptyResult(t *testing.T) {
+	cases := map[string]bool{
+		`{"data":{}}`:                                           true,
+		`{"data":{"allEthHeaderCids":null}}`:                    true,
+		`{"data":{"allEthHeaderCids":{"nodes":[]}}}`:            true,
+		`{"data":{"allEthHeaderCids":{"edges":[]}}}`:            true,
+		`{"data":{"allEthHeaderCids":{"nodes":[{"cid":"a"}]}}}`: false,
+		`{"data":{"allEthHeaderCids":{"blockHash":"0x01"}}}`:    false,
+	}
+	for data, want := range cases {
+		empty, err := isEmptyResult([]byte(data), "allEthHeaderCids")
+		if err != nil {
+			t.Errorf("%s: Want: nil, Got: %v", data, err)
+		}
+		if empty != want {
+			t.Errorf("%s: Want: %v, Got: %v", data, want, empty)
+		}
+	}
+}
+
+func TestAllEthHeaderCidsMissing(t *testing.T) {
+	args := parseArgs(t, `{ allEthHeaderCids(filter: {blockNumber: {greaterThanOrEqualTo: "10", lessThanOrEqualTo: "13"}}) { nodes { blockNumber } } }`)
+	cases := map[string][]uint64{
+		`{"data":{"allEthHeaderCids":null}}`:                                                                                            {10, 11, 12, 13},
+		`{"data":{"allEthHeaderCids":{"nodes":[{"blockNumber":"10"},{"blockNumber":"13"}]}}}`:                                           {11, 12},
+		`{"data":{"allEthHeaderCids":{"edges":[{"node":{"blockNumber":"11"}},{"node":{"blockNumber":"12"}}]}}}`:                         {10, 13},
+		`{"data":{"allEthHeaderCids":{"nodes":[{"cid":"a"},{"cid":"b"},{"cid":"c"},{"cid":"d"}]}}}`:                                     {},
+		`{"data":{"allEthHeaderCids":{"nodes":[{"cid":"a"}]}}}`:                                                                         {10, 11, 12, 13},
+		`{"data":{"allEthHeaderCids":{"nodes":[{"blockNumber":"10"},{"blockNumber":"11"},{"blockNumber":"12"},{"blockNumber":"13"}]}}}`: {},
+	}
+	srv := NewAllEthHeaderCidsService(nil)
+	for data, want := range cases {
+		missing, err := srv.Missing(args, []byte(data))
+		if err != nil {
+			t.Errorf("%s: Want: nil, Got: %v", data, err)
+		}
+		if !reflect.DeepEqual(missing, want) {
+			t.Errorf("%s: Want: %v, Got: %v", data, want, missing)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package qlservices
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/sirupsen/logrus"
+	"github.com/vulcanize/gap-filler/pkg/rpcpool"
+)
+
+type EthHeaderCidByBlockHashService struct {
+	pool *rpcpool.Pool
+}
+
+func NewEthHeaderCidByBlockHashService(pool *rpcpool.Pool) *EthHeaderCidByBlockHashService {
+	return &EthHeaderCidByBlockHashService{pool: pool}
+}
+
+func (srv *EthHeaderCidByBlockHashService) Name() string {
+	return "ethHeaderCidByBlockHash"
+}
+
+func (srv *EthHeaderCidByBlockHashService) Validate(args []*ast.Argument) error {
+	_, err := hashArg(args, "blockHash")
+	return err
+}
+
+func (srv *EthHeaderCidByBlockHashService) IsEmpty(data []byte) (bool, error) {
+	return isEmptyResult(data, srv.Name())
+}
+
+func (srv *EthHeaderCidByBlockHashService) Do(ctx context.Context, args []*ast.Argument) error {
+	hash, err := hashArg(args, "blockHash")
+	if err != nil {
+		return err
+	}
+	params := stateDiffParams()
+	log := logrus.WithFields(logrus.Fields{
+		"blockHash": hash.Hex(),
+		"params":    params,
+	})
+	log.Debug("do request to Geth")
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	var data json.RawMessage
+
+	return srv.pool.CallContext(ctx, log, &data, stateDiffForMethod, hash, params)
+}
@@ -6,21 +6,21 @@ import (
 	"math/big"
 	"time"
 
-	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/ethereum/go-ethereum/statediff"
 	"github.com/graphql-go/graphql/language/ast"
 	"github.com/sirupsen/logrus"
 	"github.com/valyala/fastjson"
+	"github.com/vulcanize/gap-filler/pkg/rpcpool"
 )
 
 var stateDiffMethod = "statediff_writeStateDiffAt"
 
 type EthHeaderCidByBlockNumberService struct {
-	clients []*rpc.Client
+	pool *rpcpool.Pool
 }
 
-func NewEthHeaderCidByBlockNumberService(clients []*rpc.Client) *EthHeaderCidByBlockNumberService {
-	return &EthHeaderCidByBlockNumberService{clients: clients}
+func NewEthHeaderCidByBlockNumberService(pool *rpcpool.Pool) *EthHeaderCidByBlockNumberService {
+	return &EthHeaderCidByBlockNumberService{pool: pool}
 }
 
 func (srv *EthHeaderCidByBlockNumberService) Name() string {
@@ -80,7 +80,7 @@ func (srv *EthHeaderCidByBlockNumberService) IsEmpty(data []byte) (bool, error)
 	return len(aEdges) == 0, nil
 }
 
-func (srv *EthHeaderCidByBlockNumberService) Do(args []*ast.Argument) error {
+func (srv *EthHeaderCidByBlockNumberService) Do(ctx context.Context, args []*ast.Argument) error {
 	n, err := srv.args(args)
 	if err != nil {
 		return err
@@ -99,9 +99,12 @@ func (srv *EthHeaderCidByBlockNumberService) Do(args []*ast.Argument) error {
 	})
 	log.Debug("do request to Geth")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
+	if err := srv.pool.CheckBlock(ctx, n.Uint64()); err != nil {
+		return err
+	}
 	var data json.RawMessage
 
-	return proxyCallContext(srv.clients, log, ctx, &data, stateDiffMethod, n.Uint64(), params)
+	return srv.pool.CallContext(ctx, log, &data, stateDiffMethod, n.Uint64(), params)
 }
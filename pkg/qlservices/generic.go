@@ -0,0 +1,229 @@
+package qlservices
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fastjson"
+	"github.com/vulcanize/gap-filler/pkg/rpcpool"
+)
+
+// Kinds of the rpc param built from the graphql argument
+const (
+	ParamNumber  = "number"
+	ParamHash    = "hash"
+	ParamAddress = "address"
+)
+
+// Names of the rpc client sets and postgraphile endpoints a service can use
+const (
+	RPCDefault          = "eth"
+	RPCTracing          = "tracing"
+	PostgraphileDefault = "default"
+	PostgraphileTracing = "tracing"
+)
+
+// ServiceConfig describes a gap-fill service declared in the config file
+//
+//	[[services]]
+//	name = "ethHeaderCidByBlockNumber"
+//	arg = "n"
+//	param = "number"
+//	method = "statediff_writeStateDiffAt"
+//	empty = ["data.ethHeaderCidByBlockNumber.nodes", "data.ethHeaderCidByBlockNumber.edges"]
+//	[services.params]
+//	includeBlock = true
+type ServiceConfig struct {
+	// Name of the graphql field
+	Name string `mapstructure:"name"`
+	// Arg is the name of the field argument passed to rpc
+	Arg string `mapstructure:"arg"`
+	// Param is the kind of the rpc param: number, hash or address
+	Param string `mapstructure:"param"`
+	// Method is the rpc method called to fill the gap
+	Method string `mapstructure:"method"`
+	// Params is an optional object passed to rpc after the argument
+	Params map[string]interface{} `mapstructure:"params"`
+	// RPC is the rpc client set: eth or tracing
+	RPC string `mapstructure:"rpc"`
+	// Postgraphile is the name of the upstream polled for the result,
+	// default, tracing or one of the configured upstreams
+	Postgraphile string `mapstructure:"postgraphile"`
+	// Empty is a list of json paths, the response is empty when all of them
+	// are missing, null or empty arrays
+	Empty []string `mapstructure:"empty"`
+}
+
+// Validate check config and set default values
+func (cfg *ServiceConfig) Validate() error {
+	if cfg.Name == "" {
+		return fmt.Errorf("service: %w", ErrNoName)
+	}
+	if cfg.Arg == "" || cfg.Method == "" {
+		return fmt.Errorf("service %s: %w", cfg.Name, ErrBadConfig)
+	}
+	switch cfg.Param {
+	case "":
+		cfg.Param = ParamNumber
+	case ParamNumber, ParamHash, ParamAddress:
+	default:
+		return fmt.Errorf("service %s: unknown param %q: %w", cfg.Name, cfg.Param, ErrBadConfig)
+	}
+	switch cfg.RPC {
+	case "":
+		cfg.RPC = RPCDefault
+	case RPCDefault, RPCTracing:
+	default:
+		return fmt.Errorf("service %s: unknown rpc %q: %w", cfg.Name, cfg.RPC, ErrBadConfig)
+	}
+	// upstream names are checked by the proxy
+	if cfg.Postgraphile == "" {
+		cfg.Postgraphile = PostgraphileDefault
+	}
+	if len(cfg.Empty) == 0 {
+		cfg.Empty = []string{"data." + cfg.Name}
+	}
+	return nil
+}
+
+// GenericService is a service built from ServiceConfig
+type GenericService struct {
+	cfg  ServiceConfig
+	pool *rpcpool.Pool
+}
+
+// NewGenericService create new service from config
+func NewGenericService(cfg ServiceConfig, pool *rpcpool.Pool) (*GenericService, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &GenericService{cfg: cfg, pool: pool}, nil
+}
+
+func (srv *GenericService) Name() string {
+	return srv.cfg.Name
+}
+
+// Postgraphile returns the name of the endpoint to poll
+func (srv *GenericService) Postgraphile() string {
+	return srv.cfg.Postgraphile
+}
+
+func (srv *GenericService) param(args []*ast.Argument) (interface{}, error) {
+	var arg *ast.Argument
+	for i := range args {
+		if args[i].Name.Value == srv.cfg.Arg {
+			arg = args[i]
+			break
+		}
+	}
+	if arg == nil {
+		return nil, ErrNoArgs
+	}
+	value, ok := arg.Value.GetValue().(string)
+	if !ok {
+		return nil, ErrBadType
+	}
+
+	switch srv.cfg.Param {
+	case ParamHash:
+		if !isHex(value, common.HashLength) {
+			return nil, ErrBadType
+		}
+		return common.HexToHash(value).Hex(), nil
+	case ParamAddress:
+		if !common.IsHexAddress(value) {
+			return nil, ErrBadType
+		}
+		return common.HexToAddress(value).Hex(), nil
+	default:
+		n, ok := new(big.Int).SetString(value, 10)
+		if !ok || !n.IsUint64() {
+			return nil, ErrBadType
+		}
+		return n.Uint64(), nil
+	}
+}
+
+func (srv *GenericService) Validate(args []*ast.Argument) error {
+	_, err := srv.param(args)
+	return err
+}
+
+func (srv *GenericService) IsEmpty(data []byte) (bool, error) {
+	json, err := fastjson.ParseBytes(data)
+	if err != nil {
+		return true, err
+	}
+
+	for _, path := range srv.cfg.Empty {
+		if !isEmptyValue(json.Get(strings.Split(path, ".")...)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (srv *GenericService) Do(ctx context.Context, args []*ast.Argument) error {
+	param, err := srv.param(args)
+	if err != nil {
+		return err
+	}
+	params := []interface{}{param}
+	if len(srv.cfg.Params) > 0 {
+		params = append(params, srv.cfg.Params)
+	}
+	log := logrus.WithFields(logrus.Fields{
+		"service": srv.cfg.Name,
+		"params":  params,
+	})
+	log.Debug("do request to Geth")
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	if n, ok := param.(uint64); ok {
+		if err := srv.pool.CheckBlock(ctx, n); err != nil {
+			return err
+		}
+	}
+	var data json.RawMessage
+
+	return srv.pool.CallContext(ctx, log, &data, srv.cfg.Method, params...)
+}
+
+func isEmptyValue(value *fastjson.Value) bool {
+	if value == nil {
+		return true
+	}
+	switch value.Type() {
+	case fastjson.TypeNull:
+		return true
+	case fastjson.TypeArray:
+		arr, _ := value.Array()
+		return len(arr) == 0
+	case fastjson.TypeObject:
+		obj, _ := value.Object()
+		return obj.Len() == 0
+	}
+	return false
+}
+
+func isHex(value string, size int) bool {
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X")
+	if len(value) != 2*size {
+		return false
+	}
+	for _, c := range value {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
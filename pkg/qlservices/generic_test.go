@@ -0,0 +1,94 @@
+package qlservices
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+func newArgs(name, value string) []*ast.Argument {
+	return []*ast.Argument{
+		ast.NewArgument(&ast.Argument{
+			Name:  ast.NewName(&ast.Name{Value: name}),
+			Value: ast.NewStringValue(&ast.StringValue{Value: value}),
+		}),
+	}
+}
+
+func TestGenericServiceBadConfig(t *testing.T) {
+	configs := []ServiceConfig{
+		{},
+		{Name: "ethHeaderCidByBlockNumber", Method: "statediff_writeStateDiffAt"},
+		{Name: "ethHeaderCidByBlockNumber", Arg: "n", Method: "statediff_writeStateDiffAt", Param: "block"},
+	}
+	for i, cfg := range configs {
+		if _, err := NewGenericService(cfg, nil); err == nil {
+			t.Errorf("[%d] Want: error, Got: nil", i)
+		}
+	}
+}
+
+func TestGenericServiceValidate(t *testing.T) {
+	type testCase struct {
+		Param string
+		Args  []*ast.Argument
+		Err   error
+	}
+	cases := []testCase{
+		{ParamNumber, newArgs("n", "123"), nil},
+		{ParamNumber, newArgs("n", "0x7b"), ErrBadType},
+		{ParamNumber, newArgs("m", "123"), ErrNoArgs},
+		{ParamHash, newArgs("n", "0xb24ca88bcc460976afd78e6887f4b94078a234d59219b523f449c2414b544c70"), nil},
+		{ParamHash, newArgs("n", "0xb24ca88b"), ErrBadType},
+		{ParamAddress, newArgs("n", "0x1f9840a85d5af5bf1d1762f925bdaddc4201f984"), nil},
+		{ParamAddress, newArgs("n", "123"), ErrBadType},
+	}
+	for i, c := range cases {
+		srv, err := NewGenericService(ServiceConfig{
+			Name:   "ethHeaderCidByBlockNumber",
+			Arg:    "n",
+			Param:  c.Param,
+			Method: "statediff_writeStateDiffAt",
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.Validate(c.Args); !errors.Is(err, c.Err) {
+			t.Errorf("[%d] Want: %v, Got: %v", i, c.Err, err)
+		}
+	}
+}
+
+func TestGenericServiceIsEmpty(t *testing.T) {
+	srv, err := NewGenericService(ServiceConfig{
+		Name:   "ethHeaderCidByBlockNumber",
+		Arg:    "n",
+		Method: "statediff_writeStateDiffAt",
+		Empty: []string{
+			"data.ethHeaderCidByBlockNumber.nodes",
+			"data.ethHeaderCidByBlockNumber.edges",
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		`{"data":{}}`: true,
+		`{"data":{"ethHeaderCidByBlockNumber":null}}`:                          true,
+		`{"data":{"ethHeaderCidByBlockNumber":{"nodes":[]}}}`:                  true,
+		`{"data":{"ethHeaderCidByBlockNumber":{"edges":[]}}}`:                  true,
+		`{"data":{"ethHeaderCidByBlockNumber":{"nodes":[{"blockNumber":1}]}}}`: false,
+		`{"data":{"ethHeaderCidByBlockNumber":{"edges":[{"cursor":"a"}]}}}`:    false,
+	}
+	for data, want := range cases {
+		empty, err := srv.IsEmpty([]byte(data))
+		if err != nil {
+			t.Errorf("%s: Want: nil, Got: %v", data, err)
+		}
+		if empty != want {
+			t.Errorf("%s: Want: %v, Got: %v", data, want, empty)
+		}
+	}
+}
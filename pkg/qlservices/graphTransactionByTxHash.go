@@ -6,27 +6,32 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/graphql-go/graphql/language/ast"
 	"github.com/sirupsen/logrus"
 	"github.com/valyala/fastjson"
+	"github.com/vulcanize/gap-filler/pkg/rpcpool"
 )
 
 var traceMethod = "debug_writeTxTraceGraph"
 
 type GraphTransactionByTxHashService struct {
-	number  int
-	clients []*rpc.Client
+	number int
+	pool   *rpcpool.Pool
 }
 
-func NewGetGraphCallByTxHashService(clients []*rpc.Client) *GraphTransactionByTxHashService {
-	return &GraphTransactionByTxHashService{clients: clients}
+func NewGetGraphCallByTxHashService(pool *rpcpool.Pool) *GraphTransactionByTxHashService {
+	return &GraphTransactionByTxHashService{pool: pool}
 }
 
 func (srv *GraphTransactionByTxHashService) Name() string {
 	return "graphTransactionByTxHash"
 }
 
+// Postgraphile returns the name of the endpoint to poll
+func (srv *GraphTransactionByTxHashService) Postgraphile() string {
+	return PostgraphileTracing
+}
+
 func (srv *GraphTransactionByTxHashService) params(args []*ast.Argument) (common.Hash, error) {
 	if len(args) == 0 {
 		return common.Hash{}, ErrNoArgs
@@ -53,7 +58,7 @@ func (srv *GraphTransactionByTxHashService) IsEmpty(data []byte) (bool, error) {
 	return header == nil || header.Type() == fastjson.TypeNull, nil
 }
 
-func (srv *GraphTransactionByTxHashService) Do(args []*ast.Argument) error {
+func (srv *GraphTransactionByTxHashService) Do(ctx context.Context, args []*ast.Argument) error {
 	hash, err := srv.params(args)
 	if err != nil {
 		return err
@@ -61,9 +66,9 @@ func (srv *GraphTransactionByTxHashService) Do(args []*ast.Argument) error {
 	log := logrus.WithField("hash", hash.Hex())
 	log.Debug("do request to Geth")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 	var data json.RawMessage
 
-	return proxyCallContext(srv.clients, log, ctx, &data, traceMethod, hash.Hex())
+	return srv.pool.CallContext(ctx, log, &data, traceMethod, hash.Hex())
 }
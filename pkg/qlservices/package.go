@@ -5,33 +5,119 @@ import (
 	"encoding/json"
 	"errors"
 
-	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/statediff"
+	"github.com/graphql-go/graphql/language/ast"
 	"github.com/sirupsen/logrus"
+	"github.com/valyala/fastjson"
+	"github.com/vulcanize/gap-filler/pkg/rpcpool"
 )
 
 var (
-	DeadlineReached = errors.New("context deadline reached")
+	stateDiffForMethod = "statediff_writeStateDiffFor"
+	txByHashMethod     = "eth_getTransactionByHash"
+)
+
+var (
+	DeadlineReached = rpcpool.ErrDeadline
+	ErrFutureBlock  = rpcpool.ErrFutureBlock
+	ErrNotConfirmed = rpcpool.ErrNotConfirmed
 	ErrNoArgs       = errors.New("no arguments")
 	ErrBadType      = errors.New("bad argument type")
+	ErrNoName       = errors.New("no service name")
+	ErrBadConfig    = errors.New("bad service config")
+	ErrBadRange     = errors.New("bad block range")
+	ErrTxNotFound   = errors.New("transaction not found")
 )
 
-func proxyCallContext(clients []*rpc.Client, log *logrus.Entry, ctx context.Context, res *json.RawMessage, method string, args ...interface{}) error {
-	var err error
-	log.Debugf("proxy call %s", method)
-	for _, client := range clients {
-		// if deadline has been reached, break
-		// otherwise it'd keep calling the rest of the clients with the exhausted deadline
-		select {
-		case <-ctx.Done():
-			return DeadlineReached
-		default:
+// stateDiffParams used for every statediff write
+func stateDiffParams() statediff.Params {
+	return statediff.Params{
+		IntermediateStateNodes:   true,
+		IntermediateStorageNodes: true,
+		IncludeBlock:             true,
+		IncludeReceipts:          true,
+		IncludeTD:                true,
+		IncludeCode:              true,
+	}
+}
+
+// stringArg get string argument by name
+func stringArg(args []*ast.Argument, name string) (string, error) {
+	for i := range args {
+		if args[i].Name.Value != name {
+			continue
 		}
-		err = client.CallContext(ctx, res, method, args...)
-		if err == nil {
-			log.WithField("resp", *res).Debugf("%s result", method)
-			return nil
+		value, ok := args[i].Value.GetValue().(string)
+		if !ok {
+			return "", ErrBadType
 		}
-		log.WithError(err).Debugf("bad %s request", method)
+		return value, nil
+	}
+	return "", ErrNoArgs
+}
+
+// hashArg get 32 bytes hex argument by name
+func hashArg(args []*ast.Argument, name string) (common.Hash, error) {
+	value, err := stringArg(args, name)
+	if err != nil {
+		return common.Hash{}, err
 	}
-	return err
+	if !isHex(value, common.HashLength) {
+		return common.Hash{}, ErrBadType
+	}
+	return common.HexToHash(value), nil
+}
+
+// isEmptyResult check postgraphile response for the query. It understands
+// connections (nodes or edges) as well as single row results
+func isEmptyResult(data []byte, name string) (bool, error) {
+	json, err := fastjson.ParseBytes(data)
+	if err != nil {
+		return true, err
+	}
+
+	result := json.Get("data", name)
+	if result == nil || result.Type() == fastjson.TypeNull {
+		return true, nil
+	}
+	if nodes := result.Get("nodes"); nodes != nil {
+		return isEmptyValue(nodes), nil
+	}
+	if edges := result.Get("edges"); edges != nil {
+		return isEmptyValue(edges), nil
+	}
+	return isEmptyValue(result), nil
+}
+
+// blockHashByTxHash find hash of the block which contains the transaction
+func blockHashByTxHash(pool *rpcpool.Pool, log *logrus.Entry, ctx context.Context, hash common.Hash) (common.Hash, error) {
+	var data json.RawMessage
+	if err := pool.CallContext(ctx, log, &data, txByHashMethod, hash.Hex()); err != nil {
+		return common.Hash{}, err
+	}
+	var tx *struct {
+		BlockHash *common.Hash `json:"blockHash"`
+	}
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return common.Hash{}, err
+	}
+	// unknown or pending transaction
+	if tx == nil || tx.BlockHash == nil {
+		return common.Hash{}, ErrTxNotFound
+	}
+	return *tx.BlockHash, nil
+}
+
+// writeStateDiffForTx write state diff for the block which contains the transaction
+func writeStateDiffForTx(pool *rpcpool.Pool, log *logrus.Entry, ctx context.Context, hash common.Hash) error {
+	blockHash, err := blockHashByTxHash(pool, log, ctx, hash)
+	if err != nil {
+		return err
+	}
+	log = log.WithField("blockHash", blockHash.Hex())
+	log.Debug("do request to Geth")
+
+	var data json.RawMessage
+	return pool.CallContext(ctx, log, &data, stateDiffForMethod, blockHash, stateDiffParams())
 }
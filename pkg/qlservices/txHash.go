@@ -0,0 +1,56 @@
+package qlservices
+
+import (
+	"context"
+	"time"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/sirupsen/logrus"
+	"github.com/vulcanize/gap-filler/pkg/rpcpool"
+)
+
+// TxHashService fill the gap of the service which is looked up by the
+// transaction hash, e.g. ethTransactionCidByTxHash or receiptCidsByTxHash
+type TxHashService struct {
+	name string
+	pool *rpcpool.Pool
+}
+
+// NewTxHashService create new service for the graphql field name
+func NewTxHashService(name string, pool *rpcpool.Pool) *TxHashService {
+	return &TxHashService{name: name, pool: pool}
+}
+
+func NewEthTransactionCidByTxHashService(pool *rpcpool.Pool) *TxHashService {
+	return NewTxHashService("ethTransactionCidByTxHash", pool)
+}
+
+func NewReceiptCidsByTxHashService(pool *rpcpool.Pool) *TxHashService {
+	return NewTxHashService("receiptCidsByTxHash", pool)
+}
+
+func (srv *TxHashService) Name() string {
+	return srv.name
+}
+
+func (srv *TxHashService) Validate(args []*ast.Argument) error {
+	_, err := hashArg(args, "txHash")
+	return err
+}
+
+func (srv *TxHashService) IsEmpty(data []byte) (bool, error) {
+	return isEmptyResult(data, srv.Name())
+}
+
+func (srv *TxHashService) Do(ctx context.Context, args []*ast.Argument) error {
+	hash, err := hashArg(args, "txHash")
+	if err != nil {
+		return err
+	}
+	log := logrus.WithField("hash", hash.Hex())
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	return writeStateDiffForTx(srv.pool, log, ctx, hash)
+}
@@ -0,0 +1,243 @@
+package rpcpool
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Endpoint is a single rpc backend of the pool. The client is dialed on the
+// first use and redialed with backoff after transport errors, so the address
+// is resolved again and backends started after gap-filler are picked up
+type Endpoint struct {
+	URL string
+	// Name of the backend in metrics and logs, it's the URL without
+	// credentials, path and query
+	Name string
+
+	inflight int64
+	// slots limit concurrent calls, unlimited when it's nil
+	slots chan struct{}
+
+	mu       sync.Mutex
+	client   *rpc.Client
+	redialAt time.Time
+	backoff  time.Duration
+	healthy  bool
+	ejected  time.Time
+	head     uint64
+	failures int
+	calls    uint64
+	errors   uint64
+	latency  time.Duration
+}
+
+// Stats of the endpoint
+type Stats struct {
+	Name     string        `json:"name"`
+	Healthy  bool          `json:"healthy"`
+	Head     uint64        `json:"head"`
+	Inflight int64         `json:"inflight"`
+	Calls    uint64        `json:"calls"`
+	Errors   uint64        `json:"errors"`
+	Latency  time.Duration `json:"latency"`
+}
+
+// NewEndpoint create endpoint, it doesn't connect until the first call
+func NewEndpoint(url string) *Endpoint {
+	return &Endpoint{URL: url, Name: backendName(url), healthy: true}
+}
+
+// backendName strip userinfo, path and query of the URL, they may carry
+// secrets, e.g. API keys of hosted nodes. IPC paths are kept as is
+func backendName(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "invalid"
+	}
+	if u.Host == "" {
+		return u.Path
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// tryAcquire take a call slot if there is a free one
+func (ep *Endpoint) tryAcquire() bool {
+	if ep.slots == nil {
+		return true
+	}
+	select {
+	case ep.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire wait for a call slot until the context is done
+func (ep *Endpoint) acquire(ctx context.Context) error {
+	if ep.slots == nil {
+		return nil
+	}
+	select {
+	case ep.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ErrDeadline
+	}
+}
+
+func (ep *Endpoint) release() {
+	if ep.slots != nil {
+		<-ep.slots
+	}
+}
+
+// dial returns connected client, it dials the backend if there is no one yet.
+// The lock isn't held while dialing, so a slow backend doesn't block stats
+// and health updates; the client dialed first wins, the others are closed
+func (ep *Endpoint) dial(ctx context.Context, minBackoff, maxBackoff time.Duration) (*rpc.Client, error) {
+	ep.mu.Lock()
+	if ep.client != nil {
+		client := ep.client
+		ep.mu.Unlock()
+		return client, nil
+	}
+	if now := time.Now(); now.Before(ep.redialAt) {
+		ep.mu.Unlock()
+		return nil, fmt.Errorf("%w: next attempt in %s", ErrRedialBackoff, ep.redialAt.Sub(now).Round(time.Millisecond))
+	}
+	ep.mu.Unlock()
+
+	client, err := rpc.DialContext(ctx, ep.URL)
+
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if err != nil {
+		ep.backoff *= 2
+		if ep.backoff < minBackoff {
+			ep.backoff = minBackoff
+		}
+		if ep.backoff > maxBackoff {
+			ep.backoff = maxBackoff
+		}
+		ep.redialAt = time.Now().Add(ep.backoff)
+		return nil, err
+	}
+	ep.backoff = 0
+	if ep.client != nil {
+		client.Close()
+		return ep.client, nil
+	}
+	ep.client = client
+	return client, nil
+}
+
+// reset drop the client after transport error, the next call will redial
+func (ep *Endpoint) reset(client *rpc.Client) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if ep.client == client {
+		ep.client = nil
+		client.Close()
+	}
+}
+
+func (ep *Endpoint) close() {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if ep.client != nil {
+		ep.client.Close()
+		ep.client = nil
+	}
+}
+
+// Stats returns current state of the endpoint
+func (ep *Endpoint) Stats() Stats {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return Stats{
+		Name:     ep.Name,
+		Healthy:  ep.healthy,
+		Head:     ep.head,
+		Inflight: atomic.LoadInt64(&ep.inflight),
+		Calls:    ep.calls,
+		Errors:   ep.errors,
+		Latency:  ep.latency,
+	}
+}
+
+func (ep *Endpoint) isHealthy() bool {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return ep.healthy
+}
+
+// observe record the call result, it returns true when the endpoint has
+// just been ejected after maxFailures transport errors in a row
+func (ep *Endpoint) observe(duration time.Duration, err error, transport bool, maxFailures int) bool {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.calls++
+	// exponentially weighted moving average
+	if ep.latency == 0 {
+		ep.latency = duration
+	} else {
+		ep.latency = (ep.latency*7 + duration) / 8
+	}
+	if err == nil || !transport {
+		ep.failures = 0
+		if err != nil {
+			ep.errors++
+		}
+		return false
+	}
+	ep.errors++
+	ep.failures++
+	if ep.healthy && ep.failures >= maxFailures {
+		ep.healthy = false
+		ep.ejected = time.Now()
+		return true
+	}
+	return false
+}
+
+// setHealthy returns true when the state was changed
+func (ep *Endpoint) setHealthy(healthy bool) bool {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if ep.healthy == healthy {
+		return false
+	}
+	ep.healthy = healthy
+	ep.failures = 0
+	if !healthy {
+		ep.ejected = time.Now()
+	}
+	return true
+}
+
+// readmit the endpoint ejected for the cooldown, it returns true when the
+// endpoint has just been re-admitted. Failures aren't reset, so the next
+// transport error ejects it again
+func (ep *Endpoint) readmit(cooldown time.Duration) bool {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if ep.healthy || time.Since(ep.ejected) < cooldown {
+		return false
+	}
+	ep.healthy = true
+	return true
+}
+
+func (ep *Endpoint) setHead(head uint64) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	ep.head = head
+}
@@ -0,0 +1,402 @@
+package rpcpool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sirupsen/logrus"
+	"github.com/vulcanize/gap-filler/pkg/prom"
+)
+
+// List of errors
+var (
+	ErrNoEndpoints     = errors.New("no rpc endpoints is available")
+	ErrDeadline        = errors.New("context deadline reached")
+	ErrUnknownStrategy = errors.New("unknown rpc pool strategy")
+	ErrNoModule        = errors.New("rpc module is not available")
+	ErrRedialBackoff   = errors.New("rpc endpoint is waiting for redial")
+	ErrFutureBlock     = errors.New("block is beyond the chain head")
+	ErrNotConfirmed    = errors.New("block isn't confirmed yet")
+)
+
+// Strategy of choosing the endpoint for the call
+type Strategy string
+
+// List of strategies
+const (
+	// RoundRobin rotate healthy endpoints
+	RoundRobin Strategy = "round-robin"
+	// LeastLoaded prefer healthy endpoints with less calls in flight, then lower latency
+	LeastLoaded Strategy = "least-loaded"
+	// Priority prefer healthy endpoints in the configured order
+	Priority Strategy = "priority"
+)
+
+// Options of the pool
+type Options struct {
+	Strategy Strategy
+	// CheckInterval is the period of health checks
+	CheckInterval time.Duration
+	// CheckTimeout limits a single health check
+	CheckTimeout time.Duration
+	// MaxFailures is the number of transport errors in a row which eject the endpoint
+	MaxFailures int
+	// Cooldown is the time the ejected endpoint waits for re-admission when
+	// health checks are disabled
+	Cooldown time.Duration
+	// Modules the endpoint must provide, e.g. statediff
+	Modules []string
+	// MinRedial is the delay before redialing unreachable endpoint, it doubles every next attempt
+	MinRedial time.Duration
+	// MaxRedial limits the delay between redials
+	MaxRedial time.Duration
+	// Confirmations is the number of blocks on top of the block required
+	// before it's filled, it protects from writing reorged state
+	Confirmations uint64
+	// MaxConcurrency limits calls in flight of a single endpoint, calls
+	// go to endpoints with free slots and wait for one when all are busy.
+	// Health checks aren't limited. Zero means no limit
+	MaxConcurrency int
+}
+
+// Pool of rpc endpoints. Calls fail over to the next endpoint, endpoints
+// failing calls or health checks are ejected and re-admitted once healthy
+type Pool struct {
+	endpoints []*Endpoint
+	opts      Options
+	next      uint32
+	quit      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// Dial create pool of addresses and start health checks. Endpoints connect
+// lazily, an unreachable address is reported but kept in the pool
+func Dial(urls []string, opts Options) (*Pool, error) {
+	endpoints := make([]*Endpoint, 0, len(urls))
+	for _, url := range urls {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		endpoints = append(endpoints, NewEndpoint(url))
+	}
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	pool, err := New(endpoints, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	wg := new(sync.WaitGroup)
+	for _, ep := range endpoints {
+		wg.Add(1)
+		go func(ep *Endpoint) {
+			defer wg.Done()
+			if err := pool.check(ep); err != nil {
+				logrus.Warnf("rpc endpoint %s is not ready, will retry later. Error: %s", ep.Name, err)
+			}
+		}(ep)
+	}
+	wg.Wait()
+	return pool, nil
+}
+
+// New create pool of endpoints and start health checks
+func New(endpoints []*Endpoint, opts Options) (*Pool, error) {
+	switch opts.Strategy {
+	case "":
+		opts.Strategy = RoundRobin
+	case RoundRobin, LeastLoaded, Priority:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStrategy, opts.Strategy)
+	}
+	if opts.CheckTimeout <= 0 {
+		opts.CheckTimeout = 5 * time.Second
+	}
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = 3
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 30 * time.Second
+	}
+	if opts.MinRedial <= 0 {
+		opts.MinRedial = time.Second
+	}
+	if opts.MaxRedial < opts.MinRedial {
+		opts.MaxRedial = 30 * opts.MinRedial
+	}
+
+	if opts.MaxConcurrency > 0 {
+		for _, ep := range endpoints {
+			ep.slots = make(chan struct{}, opts.MaxConcurrency)
+		}
+	}
+
+	pool := &Pool{
+		endpoints: endpoints,
+		opts:      opts,
+		quit:      make(chan struct{}),
+	}
+	if opts.CheckInterval > 0 {
+		pool.wg.Add(1)
+		go pool.healthChecks()
+	}
+	return pool, nil
+}
+
+// Endpoints of the pool
+func (pool *Pool) Endpoints() []*Endpoint {
+	return pool.endpoints
+}
+
+// CallContext call the method on endpoints chosen by the strategy until one
+// of them succeeds. Endpoints without free slots are skipped, they are
+// waited for in the same order when the rest have failed
+func (pool *Pool) CallContext(ctx context.Context, log *logrus.Entry, res *json.RawMessage, method string, args ...interface{}) error {
+	err := ErrNoEndpoints
+	log.Debugf("proxy call %s", method)
+	busy := make([]*Endpoint, 0)
+	for _, ep := range pool.pick() {
+		// if deadline has been reached, break
+		// otherwise it'd keep calling the rest of the endpoints with the exhausted deadline
+		select {
+		case <-ctx.Done():
+			return ErrDeadline
+		default:
+		}
+		if !ep.tryAcquire() {
+			busy = append(busy, ep)
+			continue
+		}
+
+		err = pool.call(ctx, ep, res, method, args...)
+		ep.release()
+		if err == nil {
+			log.WithField("resp", *res).Debugf("%s result", method)
+			return nil
+		}
+		log.WithError(err).WithField("backend", ep.Name).Debugf("bad %s request", method)
+	}
+	for _, ep := range busy {
+		if err := ep.acquire(ctx); err != nil {
+			return err
+		}
+
+		err = pool.call(ctx, ep, res, method, args...)
+		ep.release()
+		if err == nil {
+			log.WithField("resp", *res).Debugf("%s result", method)
+			return nil
+		}
+		log.WithError(err).WithField("backend", ep.Name).Debugf("bad %s request", method)
+	}
+	return err
+}
+
+func (pool *Pool) call(ctx context.Context, ep *Endpoint, res *json.RawMessage, method string, args ...interface{}) error {
+	atomic.AddInt64(&ep.inflight, 1)
+	defer atomic.AddInt64(&ep.inflight, -1)
+
+	start := time.Now()
+	client, err := ep.dial(ctx, pool.opts.MinRedial, pool.opts.MaxRedial)
+	if err == nil {
+		err = client.CallContext(ctx, res, method, args...)
+	}
+	duration := time.Since(start)
+	prom.RPCCall(method, ep.Name, duration, err)
+
+	// canceled calls say nothing about the endpoint
+	transport := isTransportError(err) && ctx.Err() == nil
+	if transport && client != nil {
+		ep.reset(client)
+	}
+	if ep.observe(duration, err, transport, pool.opts.MaxFailures) {
+		logrus.WithError(err).Warnf("rpc endpoint %s is ejected", ep.Name)
+		prom.BackendHealthy(ep.Name, false)
+	}
+	return err
+}
+
+// pick order endpoints for the call. Healthy endpoints go first ordered by
+// the strategy, ejected ones are the last resort. Without health checks
+// ejected endpoints are re-admitted after the cooldown
+func (pool *Pool) pick() []*Endpoint {
+	healthy := make([]*Endpoint, 0, len(pool.endpoints))
+	ejected := make([]*Endpoint, 0)
+	for _, ep := range pool.endpoints {
+		if pool.opts.CheckInterval <= 0 && ep.readmit(pool.opts.Cooldown) {
+			logrus.Infof("rpc endpoint %s is re-admitted after cooldown", ep.Name)
+			prom.BackendHealthy(ep.Name, true)
+		}
+		if ep.isHealthy() {
+			healthy = append(healthy, ep)
+		} else {
+			ejected = append(ejected, ep)
+		}
+	}
+
+	switch pool.opts.Strategy {
+	case RoundRobin:
+		if len(healthy) > 1 {
+			shift := int(atomic.AddUint32(&pool.next, 1)-1) % len(healthy)
+			healthy = append(healthy[shift:], healthy[:shift]...)
+		}
+	case LeastLoaded:
+		stats := make(map[*Endpoint]Stats, len(healthy))
+		for _, ep := range healthy {
+			stats[ep] = ep.Stats()
+		}
+		sort.SliceStable(healthy, func(i, j int) bool {
+			a, b := stats[healthy[i]], stats[healthy[j]]
+			if a.Inflight != b.Inflight {
+				return a.Inflight < b.Inflight
+			}
+			return a.Latency < b.Latency
+		})
+	}
+	return append(healthy, ejected...)
+}
+
+func (pool *Pool) healthChecks() {
+	defer pool.wg.Done()
+	ticker := time.NewTicker(pool.opts.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, ep := range pool.endpoints {
+			err := pool.check(ep)
+			if ep.setHealthy(err == nil) {
+				if err == nil {
+					logrus.Infof("rpc endpoint %s is re-admitted", ep.Name)
+				} else {
+					logrus.WithError(err).Warnf("rpc endpoint %s is ejected", ep.Name)
+				}
+			}
+			prom.BackendHealthy(ep.Name, err == nil)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-pool.quit:
+			return
+		}
+	}
+}
+
+// check the endpoint responds and provides required modules
+func (pool *Pool) check(ep *Endpoint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pool.opts.CheckTimeout)
+	defer cancel()
+
+	client, err := ep.dial(ctx, pool.opts.MinRedial, pool.opts.MaxRedial)
+	if err != nil {
+		return err
+	}
+	var head hexutil.Uint64
+	err = client.CallContext(ctx, &head, "eth_blockNumber")
+	if isTransportError(err) {
+		ep.reset(client)
+		return err
+	}
+	if err == nil {
+		ep.setHead(uint64(head))
+	}
+
+	if len(pool.opts.Modules) == 0 {
+		return nil
+	}
+	var modules map[string]string
+	if err := client.CallContext(ctx, &modules, "rpc_modules"); err != nil {
+		return err
+	}
+	for _, module := range pool.opts.Modules {
+		if _, ok := modules[module]; !ok {
+			return fmt.Errorf("%w: %s", ErrNoModule, module)
+		}
+	}
+	return nil
+}
+
+// Head returns the highest block number known by healthy endpoints, zero
+// means it's unknown
+func (pool *Pool) Head() uint64 {
+	var head uint64
+	for _, ep := range pool.endpoints {
+		stats := ep.Stats()
+		if stats.Healthy && stats.Head > head {
+			head = stats.Head
+		}
+	}
+	return head
+}
+
+// RefreshHead ask the endpoint chosen by the strategy for the current block number
+func (pool *Pool) RefreshHead(ctx context.Context) (uint64, error) {
+	for _, ep := range pool.pick() {
+		var head hexutil.Uint64
+		var res json.RawMessage
+		if err := pool.call(ctx, ep, &res, "eth_blockNumber"); err != nil {
+			continue
+		}
+		if err := json.Unmarshal(res, &head); err != nil {
+			return 0, err
+		}
+		ep.setHead(uint64(head))
+		return pool.Head(), nil
+	}
+	return 0, ErrNoEndpoints
+}
+
+// CheckBlock returns ErrFutureBlock if the block is beyond the chain head and
+// ErrNotConfirmed if it doesn't have enough confirmations yet, it doesn't
+// wait for them. The head is refreshed when the block isn't behind the known
+// one, unknown head doesn't block anything
+func (pool *Pool) CheckBlock(ctx context.Context, n uint64) error {
+	head := pool.Head()
+	if n+pool.opts.Confirmations <= head {
+		return nil
+	}
+	head, err := pool.RefreshHead(ctx)
+	if err != nil || head == 0 {
+		return nil
+	}
+	if n > head {
+		return fmt.Errorf("%w: %d > %d", ErrFutureBlock, n, head)
+	}
+	if n+pool.opts.Confirmations > head {
+		return fmt.Errorf("%w: %d of %d confirmations", ErrNotConfirmed, head-n, pool.opts.Confirmations)
+	}
+	return nil
+}
+
+// Close stop health checks and close clients
+func (pool *Pool) Close() {
+	pool.closeOnce.Do(func() {
+		close(pool.quit)
+		pool.wg.Wait()
+		for _, ep := range pool.endpoints {
+			ep.close()
+		}
+	})
+}
+
+// isTransportError is true when the endpoint didn't respond, json-rpc errors
+// mean the endpoint is alive
+func isTransportError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var rpcErr rpc.Error
+	return !errors.As(err, &rpcErr)
+}
@@ -0,0 +1,305 @@
+package schema
+
+import (
+	"errors"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/valyala/fastjson"
+)
+
+// ErrNotIntrospection is returned when the request selects other fields than
+// __schema, __type and __typename
+var ErrNotIntrospection = errors.New("not an introspection query")
+
+// introspection types of the values under the field names
+var fieldTypes = map[string]string{
+	"queryType":        "__Type",
+	"mutationType":     "__Type",
+	"subscriptionType": "__Type",
+	"types":            "__Type",
+	"type":             "__Type",
+	"ofType":           "__Type",
+	"interfaces":       "__Type",
+	"possibleTypes":    "__Type",
+	"fields":           "__Field",
+	"args":             "__InputValue",
+	"inputFields":      "__InputValue",
+	"enumValues":       "__EnumValue",
+	"directives":       "__Directive",
+}
+
+type request struct {
+	op        *ast.OperationDefinition
+	fragments map[string]*ast.FragmentDefinition
+	variables *fastjson.Value
+}
+
+func parseRequest(body []byte) (*request, error) {
+	req, err := fastjson.ParseBytes(body)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: req.GetStringBytes("query"),
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &request{
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: req.Get("variables"),
+	}
+	operationName := string(req.GetStringBytes("operationName"))
+	for i := range doc.Definitions {
+		switch def := doc.Definitions[i].(type) {
+		case *ast.FragmentDefinition:
+			r.fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			if operationName != "" && (def.Name == nil || def.Name.Value != operationName) {
+				continue
+			}
+			if r.op != nil {
+				// ambiguous without operationName
+				return nil, ErrNotIntrospection
+			}
+			r.op = def
+		}
+	}
+	if r.op == nil || r.op.Operation != "query" {
+		return nil, ErrNotIntrospection
+	}
+	return r, nil
+}
+
+// rootFields of the operation with root fragments inlined
+func (r *request) rootFields(selections []ast.Selection, visited map[string]bool) []*ast.Field {
+	fields := make([]*ast.Field, 0, len(selections))
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			fields = append(fields, selection)
+		case *ast.InlineFragment:
+			fields = append(fields, r.rootFields(selection.SelectionSet.Selections, visited)...)
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			if fragment, ok := r.fragments[name]; ok && !visited[name] {
+				visited[name] = true
+				fields = append(fields, r.rootFields(fragment.SelectionSet.Selections, visited)...)
+			}
+		}
+	}
+	return fields
+}
+
+// IsIntrospection reports whether the request is a query of introspection
+// fields only, __typename alone isn't an introspection
+func IsIntrospection(body []byte) bool {
+	r, err := parseRequest(body)
+	if err != nil {
+		return false
+	}
+	found := false
+	for _, field := range r.rootFields(r.op.SelectionSet.Selections, make(map[string]bool)) {
+		switch field.Name.Value {
+		case "__schema", "__type":
+			found = true
+		case "__typename":
+		default:
+			return false
+		}
+	}
+	return found
+}
+
+// Execute the introspection query against the schema, it's the JSON of
+// `__schema` as returned by Query. The schema is parsed on every call, values
+// of fastjson are unescaped lazily, so they can't be read concurrently. The
+// result is the graphql response
+func Execute(schemaJSON []byte, body []byte) ([]byte, error) {
+	schema, err := fastjson.ParseBytes(schemaJSON)
+	if err != nil {
+		return nil, err
+	}
+	r, err := parseRequest(body)
+	if err != nil {
+		return nil, err
+	}
+	ex := &executor{
+		request: r,
+		arena:   new(fastjson.Arena),
+		types:   make(map[string]*fastjson.Value),
+	}
+	for _, typ := range schema.GetArray("types") {
+		ex.types[string(typ.GetStringBytes("name"))] = typ
+	}
+
+	data := ex.arena.NewObject()
+	queryType := string(schema.GetStringBytes("queryType", "name"))
+	for _, field := range r.rootFields(r.op.SelectionSet.Selections, make(map[string]bool)) {
+		if ex.skip(field.Directives) {
+			continue
+		}
+		var value *fastjson.Value
+		switch field.Name.Value {
+		case "__schema":
+			value = ex.resolve(schema, "__Schema", field.SelectionSet)
+		case "__type":
+			name, _ := ex.argument(field, "name").(string)
+			value = ex.resolve(ex.types[name], "__Type", field.SelectionSet)
+		case "__typename":
+			value = ex.arena.NewString(queryType)
+		default:
+			return nil, ErrNotIntrospection
+		}
+		data.Set(responseKey(field), value)
+	}
+	result := ex.arena.NewObject()
+	result.Set("data", data)
+	return result.MarshalTo(nil), nil
+}
+
+type executor struct {
+	*request
+	arena *fastjson.Arena
+	types map[string]*fastjson.Value
+}
+
+// resolve the selection set on the value of the introspection type
+func (ex *executor) resolve(value *fastjson.Value, typename string, set *ast.SelectionSet) *fastjson.Value {
+	if value == nil || value.Type() == fastjson.TypeNull {
+		return ex.arena.NewNull()
+	}
+	if value.Type() == fastjson.TypeArray {
+		arr := ex.arena.NewArray()
+		for i, item := range value.GetArray() {
+			arr.SetArrayItem(i, ex.resolve(item, typename, set))
+		}
+		return arr
+	}
+	if set == nil {
+		return value
+	}
+	// type references carry only the name, the rest is in the named type
+	if typename == "__Type" {
+		if full, ok := ex.types[string(value.GetStringBytes("name"))]; ok {
+			value = full
+		}
+	}
+	obj := ex.arena.NewObject()
+	ex.collect(obj, value, typename, set.Selections)
+	return obj
+}
+
+func (ex *executor) collect(obj, value *fastjson.Value, typename string, selections []ast.Selection) {
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if ex.skip(selection.Directives) {
+				continue
+			}
+			obj.Set(responseKey(selection), ex.field(value, typename, selection))
+		case *ast.InlineFragment:
+			if ex.skip(selection.Directives) || selection.TypeCondition != nil && selection.TypeCondition.Name.Value != typename {
+				continue
+			}
+			ex.collect(obj, value, typename, selection.SelectionSet.Selections)
+		case *ast.FragmentSpread:
+			fragment, ok := ex.fragments[selection.Name.Value]
+			if !ok || ex.skip(selection.Directives) || fragment.TypeCondition.Name.Value != typename {
+				continue
+			}
+			ex.collect(obj, value, typename, fragment.SelectionSet.Selections)
+		}
+	}
+}
+
+func (ex *executor) field(value *fastjson.Value, typename string, field *ast.Field) *fastjson.Value {
+	name := field.Name.Value
+	if name == "__typename" {
+		return ex.arena.NewString(typename)
+	}
+	child := value.Get(name)
+	if (name == "fields" || name == "enumValues") && child != nil && child.Type() == fastjson.TypeArray {
+		if deprecated, _ := ex.argument(field, "includeDeprecated").(bool); !deprecated {
+			filtered := ex.arena.NewArray()
+			n := 0
+			for _, item := range child.GetArray() {
+				if !item.GetBool("isDeprecated") {
+					filtered.SetArrayItem(n, item)
+					n++
+				}
+			}
+			child = filtered
+		}
+	}
+	return ex.resolve(child, fieldTypes[name], field.SelectionSet)
+}
+
+// argument value of the field, variables are resolved
+func (ex *executor) argument(field *ast.Field, name string) interface{} {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value == name {
+			return ex.value(arg.Value)
+		}
+	}
+	return nil
+}
+
+func (ex *executor) value(value ast.Value) interface{} {
+	switch value := value.(type) {
+	case *ast.Variable:
+		v := ex.variables.Get(value.Name.Value)
+		if v == nil {
+			return nil
+		}
+		switch v.Type() {
+		case fastjson.TypeString:
+			return string(v.GetStringBytes())
+		case fastjson.TypeTrue:
+			return true
+		case fastjson.TypeFalse:
+			return false
+		}
+		return nil
+	case *ast.StringValue:
+		return value.Value
+	case *ast.BooleanValue:
+		return value.Value
+	}
+	return nil
+}
+
+// skip reports whether @skip or @include exclude the selection
+func (ex *executor) skip(directives []*ast.Directive) bool {
+	for _, directive := range directives {
+		var cond interface{}
+		for _, arg := range directive.Arguments {
+			if arg.Name.Value == "if" {
+				cond = ex.value(arg.Value)
+			}
+		}
+		switch directive.Name.Value {
+		case "skip":
+			if cond == true {
+				return true
+			}
+		case "include":
+			if cond == false {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func responseKey(field *ast.Field) string {
+	if field.Alias != nil {
+		return field.Alias.Value
+	}
+	return field.Name.Value
+}
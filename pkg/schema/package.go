@@ -0,0 +1,354 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+// Query is the introspection query sent to upstreams, it's the one of
+// graphql-js with type references seven levels deep
+const Query = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives {
+      name
+      description
+      locations
+      args { ...InputValue }
+    }
+  }
+}
+
+fragment FullType on __Type {
+  kind
+  name
+  description
+  fields(includeDeprecated: true) {
+    name
+    description
+    args { ...InputValue }
+    type { ...TypeRef }
+    isDeprecated
+    deprecationReason
+  }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) {
+    name
+    description
+    isDeprecated
+    deprecationReason
+  }
+  possibleTypes { ...TypeRef }
+}
+
+fragment InputValue on __InputValue {
+  name
+  description
+  type { ...TypeRef }
+  defaultValue
+}
+
+fragment TypeRef on __Type {
+  kind
+  name
+  ofType {
+    kind
+    name
+    ofType {
+      kind
+      name
+      ofType {
+        kind
+        name
+        ofType {
+          kind
+          name
+          ofType {
+            kind
+            name
+            ofType {
+              kind
+              name
+              ofType {
+                kind
+                name
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+// Request builds the JSON request of the introspection query
+func Request() []byte {
+	arena := new(fastjson.Arena)
+	obj := arena.NewObject()
+	obj.Set("query", arena.NewString(Query))
+	obj.Set("operationName", arena.NewString("IntrospectionQuery"))
+	return obj.MarshalTo(nil)
+}
+
+// Parse the introspection response of the upstream, it returns the value
+// of `data.__schema`
+func Parse(body []byte) (*fastjson.Value, error) {
+	result, err := fastjson.ParseBytes(body)
+	if err != nil {
+		return nil, err
+	}
+	schema := result.Get("data", "__schema")
+	if schema == nil || schema.Type() != fastjson.TypeObject {
+		msgs := make([]string, 0)
+		for _, item := range result.GetArray("errors") {
+			msgs = append(msgs, string(item.GetStringBytes("message")))
+		}
+		if len(msgs) == 0 {
+			msgs = append(msgs, "no __schema in the response")
+		}
+		return nil, errors.New(strings.Join(msgs, "; "))
+	}
+	return schema, nil
+}
+
+// Source is the introspected schema of the upstream, it's the value of
+// `data.__schema`
+type Source struct {
+	Upstream string
+	Schema   *fastjson.Value
+}
+
+// Conflict is a definition of the upstream which differs from the kept one,
+// the definition of the other upstream is dropped
+type Conflict struct {
+	Type    string `json:"type"`
+	Field   string `json:"field,omitempty"`
+	Kept    string `json:"kept"`
+	Dropped string `json:"dropped"`
+	Reason  string `json:"reason"`
+}
+
+func (c Conflict) String() string {
+	name := c.Type
+	if c.Field != "" {
+		name += "." + c.Field
+	}
+	return fmt.Sprintf("%s: %s, %s is kept over %s", name, c.Reason, c.Kept, c.Dropped)
+}
+
+// merger keeps the merged definitions in the order of their appearance
+type merger struct {
+	arena     *fastjson.Arena
+	owner     func(field string) string
+	roots     map[string]string
+	types     []*mergedType
+	index     map[string]*mergedType
+	dirs      []*fastjson.Value
+	dirIndex  map[string]bool
+	conflicts []Conflict
+}
+
+type mergedType struct {
+	name     string
+	upstream string
+	value    *fastjson.Value
+	// members are fields, input fields, enum values or possible types
+	members map[string]map[string]*member
+}
+
+type member struct {
+	upstream string
+	value    *fastjson.Value
+	index    int
+}
+
+// lists of the type merged by their names
+var memberLists = []string{"fields", "inputFields", "enumValues", "possibleTypes", "interfaces"}
+
+// Merge schemas of upstreams, the first source is the base one. Types of the
+// same name are merged by their fields, input fields, enum values and
+// possible types. Root operation types are merged into the ones of the base
+// schema. Definitions which differ are reported as conflicts, the one seen
+// first is kept, except for root query fields: the definition of the owner
+// upstream of the field wins then
+func Merge(sources []Source, owner func(field string) string) (*fastjson.Value, []Conflict) {
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	m := &merger{
+		arena:    new(fastjson.Arena),
+		owner:    owner,
+		roots:    make(map[string]string),
+		index:    make(map[string]*mergedType),
+		dirIndex: make(map[string]bool),
+	}
+	base := sources[0].Schema
+	for _, root := range []string{"queryType", "mutationType", "subscriptionType"} {
+		if name := base.GetStringBytes(root, "name"); name != nil {
+			m.roots[root] = string(name)
+		}
+	}
+
+	for _, source := range sources {
+		// root types of other upstreams are renamed to the base ones
+		rename := make(map[string]string)
+		for _, root := range []string{"queryType", "mutationType", "subscriptionType"} {
+			name := source.Schema.GetStringBytes(root, "name")
+			if name == nil {
+				continue
+			}
+			if _, ok := m.roots[root]; !ok {
+				m.roots[root] = string(name)
+			}
+			rename[string(name)] = m.roots[root]
+		}
+		for _, typ := range source.Schema.GetArray("types") {
+			name := string(typ.GetStringBytes("name"))
+			if renamed, ok := rename[name]; ok {
+				name = renamed
+			}
+			m.mergeType(source.Upstream, name, typ, name == m.roots["queryType"])
+		}
+		for _, dir := range source.Schema.GetArray("directives") {
+			name := string(dir.GetStringBytes("name"))
+			if !m.dirIndex[name] {
+				m.dirIndex[name] = true
+				m.dirs = append(m.dirs, dir)
+			}
+		}
+	}
+	return m.build(), m.conflicts
+}
+
+func (m *merger) mergeType(upstream, name string, typ *fastjson.Value, query bool) {
+	existing, ok := m.index[name]
+	if !ok {
+		t := &mergedType{
+			name:     name,
+			upstream: upstream,
+			value:    typ,
+			members:  make(map[string]map[string]*member),
+		}
+		for _, list := range memberLists {
+			t.members[list] = make(map[string]*member)
+			for i, item := range typ.GetArray(list) {
+				t.members[list][string(item.GetStringBytes("name"))] = &member{upstream, item, i}
+			}
+		}
+		m.index[name] = t
+		m.types = append(m.types, t)
+		return
+	}
+
+	if kind, other := string(existing.value.GetStringBytes("kind")), string(typ.GetStringBytes("kind")); kind != other {
+		m.conflicts = append(m.conflicts, Conflict{
+			Type:    name,
+			Kept:    existing.upstream,
+			Dropped: upstream,
+			Reason:  fmt.Sprintf("kind %s differs from %s", kind, other),
+		})
+		return
+	}
+	for _, list := range memberLists {
+		members := existing.members[list]
+		for _, item := range typ.GetArray(list) {
+			field := string(item.GetStringBytes("name"))
+			kept, ok := members[field]
+			if !ok {
+				members[field] = &member{upstream, item, len(members)}
+				continue
+			}
+			if signature(kept.value) == signature(item) {
+				continue
+			}
+			conflict := Conflict{
+				Type:    name,
+				Field:   field,
+				Kept:    kept.upstream,
+				Dropped: upstream,
+				Reason:  "definitions differ",
+			}
+			if query && list == "fields" && m.owner != nil && m.owner(field) == upstream && kept.upstream != upstream {
+				conflict.Kept, conflict.Dropped = upstream, kept.upstream
+				members[field] = &member{upstream, item, kept.index}
+			}
+			m.conflicts = append(m.conflicts, conflict)
+		}
+	}
+}
+
+// signature of the definition, descriptions don't take part in it
+func signature(value *fastjson.Value) string {
+	switch value.Type() {
+	case fastjson.TypeObject:
+		sig := "{"
+		value.GetObject().Visit(func(key []byte, v *fastjson.Value) {
+			if string(key) == "description" || string(key) == "deprecationReason" {
+				return
+			}
+			sig += string(key) + ":" + signature(v) + ","
+		})
+		return sig + "}"
+	case fastjson.TypeArray:
+		sig := "["
+		for _, item := range value.GetArray() {
+			sig += signature(item) + ","
+		}
+		return sig + "]"
+	}
+	return value.String()
+}
+
+func (m *merger) build() *fastjson.Value {
+	a := m.arena
+	schema := a.NewObject()
+	for _, root := range []string{"queryType", "mutationType", "subscriptionType"} {
+		name, ok := m.roots[root]
+		if !ok {
+			schema.Set(root, a.NewNull())
+			continue
+		}
+		ref := a.NewObject()
+		ref.Set("name", a.NewString(name))
+		schema.Set(root, ref)
+	}
+
+	types := a.NewArray()
+	for i, t := range m.types {
+		typ := a.NewObject()
+		t.value.GetObject().Visit(func(key []byte, v *fastjson.Value) {
+			typ.Set(string(key), v)
+		})
+		typ.Set("name", a.NewString(t.name))
+		for _, list := range memberLists {
+			if len(t.members[list]) == 0 {
+				continue
+			}
+			items := make([]*fastjson.Value, len(t.members[list]))
+			for _, member := range t.members[list] {
+				items[member.index] = member.value
+			}
+			arr := a.NewArray()
+			for j, item := range items {
+				arr.SetArrayItem(j, item)
+			}
+			typ.Set(list, arr)
+		}
+		types.SetArrayItem(i, typ)
+	}
+	schema.Set("types", types)
+
+	dirs := a.NewArray()
+	for i, dir := range m.dirs {
+		dirs.SetArrayItem(i, dir)
+	}
+	schema.Set("directives", dirs)
+	return schema
+}
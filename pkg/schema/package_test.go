@@ -0,0 +1,196 @@
+package schema
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/valyala/fastjson"
+)
+
+const defaultSchema = `{
+	"queryType": {"name": "Query"},
+	"mutationType": null,
+	"subscriptionType": null,
+	"types": [
+		{"kind": "OBJECT", "name": "Query", "fields": [
+			{"name": "node", "args": [{"name": "nodeId", "type": {"kind": "NON_NULL", "name": null, "ofType": {"kind": "SCALAR", "name": "ID", "ofType": null}}}], "type": {"kind": "OBJECT", "name": "EthHeaderCid", "ofType": null}, "isDeprecated": false},
+			{"name": "status", "args": [], "type": {"kind": "SCALAR", "name": "String", "ofType": null}, "isDeprecated": false},
+			{"name": "old", "args": [], "type": {"kind": "SCALAR", "name": "String", "ofType": null}, "isDeprecated": true, "deprecationReason": "gone"}
+		]},
+		{"kind": "OBJECT", "name": "EthHeaderCid", "description": "header", "fields": [
+			{"name": "cid", "args": [], "type": {"kind": "SCALAR", "name": "String", "ofType": null}, "isDeprecated": false}
+		]},
+		{"kind": "SCALAR", "name": "String"},
+		{"kind": "SCALAR", "name": "ID"}
+	],
+	"directives": [{"name": "include", "locations": ["FIELD"], "args": []}]
+}`
+
+const tracingSchema = `{
+	"queryType": {"name": "TracingQuery"},
+	"mutationType": null,
+	"subscriptionType": null,
+	"types": [
+		{"kind": "OBJECT", "name": "TracingQuery", "fields": [
+			{"name": "graphCallByTxHash", "args": [], "type": {"kind": "OBJECT", "name": "GraphCall", "ofType": null}, "isDeprecated": false},
+			{"name": "status", "args": [], "type": {"kind": "SCALAR", "name": "Int", "ofType": null}, "isDeprecated": false}
+		]},
+		{"kind": "OBJECT", "name": "EthHeaderCid", "description": "other description", "fields": [
+			{"name": "cid", "args": [], "type": {"kind": "SCALAR", "name": "String", "ofType": null}, "isDeprecated": false},
+			{"name": "blockNumber", "args": [], "type": {"kind": "SCALAR", "name": "String", "ofType": null}, "isDeprecated": false}
+		]},
+		{"kind": "OBJECT", "name": "GraphCall", "fields": [
+			{"name": "id", "args": [], "type": {"kind": "SCALAR", "name": "ID", "ofType": null}, "isDeprecated": false}
+		]},
+		{"kind": "ENUM", "name": "String"},
+		{"kind": "SCALAR", "name": "Int"}
+	],
+	"directives": [{"name": "include", "locations": ["FIELD"], "args": []}, {"name": "skip", "locations": ["FIELD"], "args": []}]
+}`
+
+func merged(t *testing.T, owner func(string) string) (*fastjson.Value, []Conflict) {
+	sources := []Source{
+		{Upstream: "default", Schema: fastjson.MustParse(defaultSchema)},
+		{Upstream: "tracing", Schema: fastjson.MustParse(tracingSchema)},
+	}
+	return Merge(sources, owner)
+}
+
+func TestMerge(t *testing.T) {
+	schema, conflicts := merged(t, func(field string) string {
+		if field == "status" {
+			return "tracing"
+		}
+		return "default"
+	})
+
+	names := make([]string, 0)
+	for _, typ := range schema.GetArray("types") {
+		names = append(names, string(typ.GetStringBytes("name")))
+	}
+	if got := strings.Join(names, ","); got != "Query,EthHeaderCid,String,ID,GraphCall,Int" {
+		t.Errorf("Want: merged types, Got: %s", got)
+	}
+	if got := string(schema.GetStringBytes("queryType", "name")); got != "Query" {
+		t.Errorf("Want: Query, Got: %s", got)
+	}
+	if n := len(schema.GetArray("directives")); n != 2 {
+		t.Errorf("Want: 2 directives, Got: %d", n)
+	}
+
+	fields := make(map[string]string)
+	for _, typ := range schema.GetArray("types") {
+		for _, field := range typ.GetArray("fields") {
+			fields[string(typ.GetStringBytes("name"))+"."+string(field.GetStringBytes("name"))] = string(field.GetStringBytes("type", "name"))
+		}
+	}
+	for name, want := range map[string]string{
+		"Query.node":              "",
+		"Query.graphCallByTxHash": "GraphCall",
+		// the tracing upstream owns the field
+		"Query.status":             "Int",
+		"EthHeaderCid.blockNumber": "String",
+	} {
+		if got, ok := fields[name]; !ok || want != "" && got != want {
+			t.Errorf("[%s] Want: %q, Got: %q %v", name, want, got, ok)
+		}
+	}
+
+	want := []Conflict{
+		{Type: "Query", Field: "status", Kept: "tracing", Dropped: "default", Reason: "definitions differ"},
+		{Type: "String", Kept: "default", Dropped: "tracing", Reason: "kind SCALAR differs from ENUM"},
+	}
+	if len(conflicts) != len(want) {
+		t.Fatalf("Want: %v, Got: %v", want, conflicts)
+	}
+	for i := range want {
+		if conflicts[i] != want[i] {
+			t.Errorf("[%d] Want: %v, Got: %v", i, want[i], conflicts[i])
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	value, _ := merged(t, nil)
+	schema := value.MarshalTo(nil)
+	for _, tc := range []struct {
+		request string
+		want    string
+	}{
+		{
+			`{"query":"{ __schema { queryType { name fields { name } } } }"}`,
+			`{"data":{"__schema":{"queryType":{"name":"Query","fields":[{"name":"node"},{"name":"status"},{"name":"graphCallByTxHash"}]}}}}`,
+		},
+		{
+			`{"query":"query Q($name: String!) { t: __type(name: $name) { kind ...F } __typename } fragment F on __Type { fields { name type { name kind } } }","variables":{"name":"GraphCall"}}`,
+			`{"data":{"t":{"kind":"OBJECT","fields":[{"name":"id","type":{"name":"ID","kind":"SCALAR"}}]},"__typename":"Query"}}`,
+		},
+		{
+			`{"query":"{ __type(name: \"Query\") { fields(includeDeprecated: true) { name @skip(if: true) isDeprecated } } }"}`,
+			`{"data":{"__type":{"fields":[{"isDeprecated":false},{"isDeprecated":false},{"isDeprecated":true},{"isDeprecated":false}]}}}`,
+		},
+		{
+			`{"query":"{ __type(name: \"Unknown\") { name } }"}`,
+			`{"data":{"__type":null}}`,
+		},
+		{
+			`{"query":"{ __type(name: \"Query\") { fields { name type { name ofType { name } fields { name } } } } }"}`,
+			`{"data":{"__type":{"fields":[{"name":"node","type":{"name":"EthHeaderCid","ofType":null,"fields":[{"name":"cid"},{"name":"blockNumber"}]}},{"name":"status","type":{"name":"String","ofType":null,"fields":null}},{"name":"graphCallByTxHash","type":{"name":"GraphCall","ofType":null,"fields":[{"name":"id"}]}}]}}}`,
+		},
+	} {
+		got, err := Execute(schema, []byte(tc.request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s\nWant: %s\nGot:  %s", tc.request, tc.want, got)
+		}
+	}
+}
+
+func TestExecuteConcurrent(t *testing.T) {
+	value, _ := merged(t, nil)
+	schema := value.MarshalTo(nil)
+	request := []byte(`{"query":"{ __schema { types { name fields { name type { name } } } } }"}`)
+	want, err := Execute(schema, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got, err := Execute(schema, request)
+			if err != nil || string(got) != string(want) {
+				t.Errorf("[%d] Want: %s, Got: %s %v", i, want, got, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestIsIntrospection(t *testing.T) {
+	for request, want := range map[string]bool{
+		`{"query":"{ __schema { types { name } } }"}`:                                       true,
+		`{"query":"query A { ...F } fragment F on Query { __type(name: \"A\") { name } }"}`: true,
+		`{"query":"{ __typename }"}`:                                                        false,
+		`{"query":"{ __schema { types { name } } node(nodeId: \"a\") { id } }"}`:            false,
+		`{"query":"mutation { __typename }"}`:                                               false,
+		`bad`:                                                                               false,
+	} {
+		if got := IsIntrospection([]byte(request)); got != want {
+			t.Errorf("%s Want: %v, Got: %v", request, want, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := Parse([]byte(`{"data":{"__schema":` + defaultSchema + `}}`)); err != nil {
+		t.Error(err)
+	}
+	if _, err := Parse([]byte(`{"errors":[{"message":"introspection is disabled"}]}`)); err == nil || err.Error() != "introspection is disabled" {
+		t.Errorf("Want: introspection is disabled, Got: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// List of errors
+var (
+	ErrNoKeyPair  = errors.New("both tls certificate and key are required")
+	ErrNoClientCA = errors.New("no certificates found in client ca file")
+)
+
+// Options of the TLS listener
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables mutual TLS, clients must present a certificate
+	// signed by one of the CAs
+	ClientCAFile string
+}
+
+// Enabled reports whether any file is set, New fails then unless both the
+// certificate and the key are set
+func (opts Options) Enabled() bool {
+	return opts.CertFile != "" || opts.KeyFile != "" || opts.ClientCAFile != ""
+}
+
+// Reloader keeps the server certificate and client CAs in sync with the
+// files, they are reloaded when the files change on disk. A broken update
+// is reported and the previous certificates are kept
+type Reloader struct {
+	opts    Options
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	cas     *x509.CertPool
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+// New load certificates and start watching the files
+func New(opts Options) (*Reloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, ErrNoKeyPair
+	}
+	r := &Reloader{opts: opts, done: make(chan struct{})}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// directories are watched since certificates are usually replaced by
+	// rename or symlink swap, e.g. kubernetes secrets
+	dirs := make(map[string]bool)
+	for _, file := range r.files() {
+		dir := filepath.Dir(file)
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	r.watcher = watcher
+	go r.watch()
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	return files
+}
+
+func (r *Reloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return err
+	}
+	var cas *x509.CertPool
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		cas = x509.NewCertPool()
+		if !cas.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: %w", r.opts.ClientCAFile, ErrNoClientCA)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.cas = cas
+	return nil
+}
+
+func (r *Reloader) watch() {
+	defer close(r.done)
+	watched := make(map[string]bool)
+	for _, file := range r.files() {
+		watched[filepath.Clean(file)] = true
+	}
+
+	for {
+		select {
+		case ev, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+			// kubernetes swaps the ..data symlink, other files of the
+			// directory don't matter
+			name := filepath.Base(ev.Name)
+			if !watched[filepath.Clean(ev.Name)] && name != "..data" {
+				continue
+			}
+			if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+				continue
+			}
+			if err := r.load(); err != nil {
+				logrus.WithError(err).Warn("couldn't reload tls certificates, keep the previous ones")
+				continue
+			}
+			logrus.Info("tls certificates are reloaded")
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+			logrus.WithError(err).Warn("tls certificates watcher")
+		}
+	}
+}
+
+// Config of the server, certificates are taken on every handshake. HTTP/2
+// isn't negotiated since websocket upgrades need HTTP/1.1
+func (r *Reloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				NextProtos:   []string{"http/1.1"},
+			}
+			if r.cas != nil {
+				cfg.ClientCAs = r.cas
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// Close stop watching the files
+func (r *Reloader) Close() error {
+	err := r.watcher.Close()
+	<-r.done
+	return err
+}
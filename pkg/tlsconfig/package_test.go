@@ -0,0 +1,171 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newCert issue a certificate signed by the parent, self-signed if it's nil
+func newCert(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func writeCert(t *testing.T, cert tls.Certificate, certFile, keyFile string) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serve(t *testing.T, r *Reloader) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = r.Config()
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func peerName(ts *httptest.Server, cfg *tls.Config) (string, error) {
+	conn, err := tls.Dial("tcp", ts.Listener.Addr().String(), cfg)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		return "", err
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeCert(t, newCert(t, "first", nil), certFile, keyFile)
+
+	r, err := New(Options{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ts := serve(t, r)
+
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	if name, err := peerName(ts, cfg); err != nil || name != "first" {
+		t.Fatalf("Want: first, Got: %s, %v", name, err)
+	}
+
+	writeCert(t, newCert(t, "second", nil), certFile, keyFile)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		name, err := peerName(ts, cfg)
+		if err == nil && name == "second" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Want: second, Got: %s, %v", name, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	ca := newCert(t, "ca", nil)
+	writeCert(t, ca, caFile, "")
+	writeCert(t, newCert(t, "server", nil), certFile, keyFile)
+
+	r, err := New(Options{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ts := serve(t, r)
+
+	client := newCert(t, "client", &ca)
+	if _, err := peerName(ts, &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{client}}); err != nil {
+		t.Errorf("Want: client signed by ca is accepted, Got: %v", err)
+	}
+
+	rogue := newCert(t, "rogue", nil)
+	for i, certs := range [][]tls.Certificate{nil, {rogue}} {
+		conn, err := tls.Dial("tcp", ts.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, Certificates: certs})
+		if err == nil {
+			// TLS 1.3 client reports the rejected certificate on the first read
+			_, err = conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+		if err == nil {
+			t.Errorf("[%d] Want: handshake error, Got: nil", i)
+		}
+	}
+}
+
+func TestIncompleteOptions(t *testing.T) {
+	for i, opts := range []Options{
+		{KeyFile: "server.key"},
+		{ClientCAFile: "ca.pem"},
+		{CertFile: "server.pem", ClientCAFile: "ca.pem"},
+	} {
+		if !opts.Enabled() {
+			t.Errorf("[%d] Want: enabled, Got: disabled", i)
+		}
+		if _, err := New(opts); !errors.Is(err, ErrNoKeyPair) {
+			t.Errorf("[%d] Want: %v, Got: %v", i, ErrNoKeyPair, err)
+		}
+	}
+	if (Options{}).Enabled() {
+		t.Error("Want: disabled without files, Got: enabled")
+	}
+}